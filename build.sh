@@ -1,4 +1,4 @@
 rm lambda-handler.zip 2>/dev/null
-GOOS=linux GOARCH=arm64 CGO_ENABLED=0 go build -o bootstrap ./cmd/lambda/main.go
+GOOS=linux GOARCH=arm64 CGO_ENABLED=0 go build -o bootstrap ./cmd/lambda
 zip lambda-handler.zip bootstrap
 rm bootstrap
\ No newline at end of file
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+)
+
+const (
+	// The todos waiting on or given a decision, stored as one JSON list like the deferred todos
+	APPROVAL_QUEUE_KEY      = "approval_queue"
+	APPROVAL_STATE_PENDING  = "pending"
+	APPROVAL_STATE_APPROVED = "approved"
+	APPROVAL_STATE_REJECTED = "rejected"
+)
+
+// approvalItem is a todo in the approval queue. The text is what would have been tweeted when it was queued, so it can
+// be reviewed without running the bridge. An approved todo is planned again when it's tweeted.
+type approvalItem struct {
+	deferredTodo
+	State     string    `json:"state"`
+	Text      string    `json:"text"`
+	QueuedAt  time.Time `json:"queued_at"`
+	DecidedAt time.Time `json:"decided_at,omitempty"`
+}
+
+// Returned by an update of the approval queue to leave it as it was
+var errTodoNotQueued = errors.New("todo isn't in the approval queue")
+
+func parseApprovalQueue(value string) ([]approvalItem, error) {
+	approvalQueue := []approvalItem{}
+	if value == "" {
+		return approvalQueue, nil
+	}
+	if err := json.Unmarshal([]byte(value), &approvalQueue); err != nil {
+		return nil, fmt.Errorf("could not unmarshal the approval queue: %w", err)
+	}
+	return approvalQueue, nil
+}
+
+func loadApprovalQueue(ctx context.Context, stateStore lib_state.Store) ([]approvalItem, error) {
+	value, err := stateStore.GetValue(ctx, APPROVAL_QUEUE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return parseApprovalQueue(value)
+}
+
+// Changes the approval queue in the state store without losing the changes another run made at the same time. update
+// may be called more than once, with the queue as it is by then.
+func updateApprovalQueue(ctx context.Context, stateStore lib_state.Store, update func(approvalQueue []approvalItem) ([]approvalItem, error)) error {
+	return stateStore.UpdateValue(ctx, APPROVAL_QUEUE_KEY, func(value string) (string, error) {
+		approvalQueue, err := parseApprovalQueue(value)
+		if err != nil {
+			return "", err
+		}
+		approvalQueue, err = update(approvalQueue)
+		if err != nil || len(approvalQueue) == 0 {
+			return "", err
+		}
+		newValue, err := json.Marshal(approvalQueue)
+		return string(newValue), err
+	})
+}
+
+// Holds the planned tweets back until they've been approved. Todos new to the queue are added as pending, approved
+// ones are planned again and returned to be tweeted, and rejected ones are marked as processed so they're never
+// queued again. Todos that have since been tweeted or are gone from WIP are dropped from the queue, so approved todos
+// stay queued until a run has actually tweeted them.
+func applyApprovalQueue(ctx context.Context, stateStore lib_state.Store, publicTodos []projectWithTodos, plannedTweets []plannedTweet, router *twitterRouter, cfg Config, now time.Time, logger *slog.Logger) ([]plannedTweet, []skippedTodo, error) {
+	var approvedTweets []plannedTweet
+	var skippedTodos []skippedTodo
+	var remainingQueue []approvalItem
+	err := updateApprovalQueue(ctx, stateStore, func(approvalQueue []approvalItem) ([]approvalItem, error) {
+		queued := map[string]bool{}
+		for _, item := range approvalQueue {
+			queued[item.TodoID] = true
+		}
+		for _, plannedTweet := range plannedTweets {
+			if !queued[plannedTweet.Todo.ID] {
+				approvalQueue = append(approvalQueue, approvalItem{deferredTodo: newDeferredTodo(plannedTweet), State: APPROVAL_STATE_PENDING, Text: plannedTweet.Text, QueuedAt: now})
+				queued[plannedTweet.Todo.ID] = true
+			}
+		}
+
+		approvedTodos := []deferredTodo{}
+		for _, item := range approvalQueue {
+			if item.State == APPROVAL_STATE_APPROVED {
+				approvedTodos = append(approvedTodos, item.deferredTodo)
+			}
+		}
+		approvedTweets, skippedTodos = planTweets(findDeferredTodos(publicTodos, approvedTodos), cfg, allTime(), logger)
+
+		remainingQueue = []approvalItem{}
+		for _, item := range approvalQueue {
+			processedID := processedTweetID(router.accountFor(item.ProjectID), item.TodoID)
+			processed, err := stateStore.IsProcessed(ctx, processedID)
+			if err != nil {
+				return nil, err
+			}
+			if processed || isDeferredTodoGone(publicTodos, item.deferredTodo) {
+				continue
+			}
+			switch item.State {
+			case APPROVAL_STATE_REJECTED:
+				if err := stateStore.MarkProcessed(ctx, processedID); err != nil {
+					return nil, err
+				}
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: item.ProjectID, TodoID: item.TodoID, Reason: "rejected"})
+				continue
+			case APPROVAL_STATE_PENDING:
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: item.ProjectID, TodoID: item.TodoID, Reason: "pending_approval"})
+			}
+			remainingQueue = append(remainingQueue, item)
+		}
+		return remainingQueue, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, skipped := range skippedTodos {
+		if skipped.Reason == "rejected" || skipped.Reason == "pending_approval" {
+			logger.Info("Skipping todo", "todo_id", skipped.TodoID, "reason", skipped.Reason)
+		}
+	}
+
+	// Approved todos the state store says were tweeted after all aren't tweeted again
+	stillQueued := map[string]bool{}
+	for _, item := range remainingQueue {
+		stillQueued[item.TodoID] = true
+	}
+	remaining := []plannedTweet{}
+	for _, plannedTweet := range approvedTweets {
+		if stillQueued[plannedTweet.Todo.ID] {
+			remaining = append(remaining, plannedTweet)
+		}
+	}
+	return remaining, skippedTodos, nil
+}
+
+// Approves or rejects queued todos, for the next run to tweet or drop. A todo can be decided again until a run has
+// acted on it, e.g. to reject something approved by mistake. With no state, lists the queue instead.
+func reviewApprovalQueue(ctx context.Context, todoIDs []string, state string) (Response, error) {
+	logger := newLogger()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return makeAndLogErrorResponse(err.Error(), "invalid_config", logger), nil
+	}
+	if !cfg.ApprovalQueue {
+		return makeAndLogErrorResponse("APPROVAL_QUEUE isn't turned on, so there's nothing to review", "approval_queue_disabled", logger), nil
+	}
+	stateStore, err := newStateStore(ctx, cfg)
+	if err != nil {
+		return makeAndLogErrorResponse("Could not set up the state store", "state_store_error", logger), err
+	}
+	if state == "" {
+		approvalQueue, err := loadApprovalQueue(ctx, stateStore)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
+		}
+		for _, item := range approvalQueue {
+			logger.Info("Queued for approval", "todo_id", item.TodoID, "project_id", item.ProjectID, "state", item.State, "queued_at", item.QueuedAt, "text", item.Text)
+		}
+		return Response{Message: SUCCESS_MESSAGE}, nil
+	}
+
+	// A run may be changing the queue at the same time, so the decisions are applied to the queue as it is when saving
+	now := time.Now().UTC()
+	notQueuedTodoID := ""
+	err = updateApprovalQueue(context.WithoutCancel(ctx), stateStore, func(approvalQueue []approvalItem) ([]approvalItem, error) {
+		for _, todoID := range todoIDs {
+			decided := false
+			for i := range approvalQueue {
+				if approvalQueue[i].TodoID == todoID {
+					approvalQueue[i].State = state
+					approvalQueue[i].DecidedAt = now
+					decided = true
+				}
+			}
+			if !decided {
+				notQueuedTodoID = todoID
+				return nil, errTodoNotQueued
+			}
+		}
+		return approvalQueue, nil
+	})
+	if errors.Is(err, errTodoNotQueued) {
+		return makeAndLogErrorResponse(fmt.Sprintf("Could not find a queued todo with the ID %s", notQueuedTodoID), "todo_not_found", logger), nil
+	}
+	if err != nil {
+		return makeAndLogErrorResponse("Could not record the decisions in the state store", "state_store_error", logger), err
+	}
+	for _, todoID := range todoIDs {
+		logger.Info("Decided on queued todo", "todo_id", todoID, "state", state)
+	}
+	return Response{Message: SUCCESS_MESSAGE}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ATTACHMENT_SORT_NONE          = "none"
+	ATTACHMENT_SORT_LARGEST_FIRST = "largest-first"
+	ATTACHMENT_SORT_MARKED_FIRST  = "marked-first"
+	// Put in an attachment's description on WIP to make it the first attachment with marked-first
+	HERO_ATTACHMENT_MARKER = "[hero]"
+)
+
+var heroAttachmentMarkerPattern = regexp.MustCompile(`(?i)\s*` + regexp.QuoteMeta(HERO_ATTACHMENT_MARKER))
+
+// Downloads an attachment, e.g. lib_media.Downloader.Download
+type downloadFunc func(url string) ([]byte, error)
+
+// Reorders the attachments so the one Twitter shows as the tweet's preview image comes first. The sort is stable, so
+// attachments that tie keep the order WIP returned them in. largest-first has to download the attachments to measure
+// them, so it returns a download func that hands back what it already downloaded rather than downloading it again.
+func sortAttachments(attachments []lib_wip.Attachment, mode string, download downloadFunc, logger *slog.Logger) ([]lib_wip.Attachment, downloadFunc) {
+	sorted := append([]lib_wip.Attachment{}, attachments...)
+	switch mode {
+	case ATTACHMENT_SORT_MARKED_FIRST:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return isHeroAttachment(sorted[i]) && !isHeroAttachment(sorted[j])
+		})
+		// The marker is only meant for us, not for the alt text
+		for i := range sorted {
+			sorted[i].Description = strings.TrimSpace(heroAttachmentMarkerPattern.ReplaceAllString(sorted[i].Description, ""))
+		}
+	case ATTACHMENT_SORT_LARGEST_FIRST:
+		downloaded := map[string][]byte{}
+		largestDimensions := map[string]int{}
+		for _, attachment := range sorted {
+			respBytes, err := download(attachment.URL)
+			// Leave it for the upload to fail on and report, and sort it last in the meantime
+			if err != nil {
+				logger.Warn("Could not download attachment to sort it", "url", attachment.URL, "error", err)
+				continue
+			}
+			downloaded[attachment.URL] = respBytes
+			// Videos and anything else that isn't an image sort last too
+			if config, _, err := image.DecodeConfig(bytes.NewReader(respBytes)); err == nil {
+				largestDimensions[attachment.URL] = max(config.Width, config.Height)
+			}
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return largestDimensions[sorted[i].URL] > largestDimensions[sorted[j].URL]
+		})
+		downloadAgain := download
+		download = func(url string) ([]byte, error) {
+			if respBytes, ok := downloaded[url]; ok {
+				return respBytes, nil
+			}
+			return downloadAgain(url)
+		}
+	}
+	return sorted, download
+}
+
+func isHeroAttachment(attachment lib_wip.Attachment) bool {
+	return heroAttachmentMarkerPattern.MatchString(attachment.Description)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// What CHANGELOG_URL_TEMPLATE can use, e.g. https://mysite.com/changelog#{{.TodoID}}
+type changelogURLFields struct {
+	TodoID      string
+	ProjectID   string
+	ProjectSlug string
+	ProjectName string
+}
+
+// Parses the template and tries it out on made up fields, so a typo in a field name fails at startup rather than on the
+// first tweet
+func parseChangelogURLTemplate(text string) (*template.Template, error) {
+	changelogURLTemplate, err := template.New("changelog_url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := changelogURLTemplate.Execute(&strings.Builder{}, changelogURLFields{}); err != nil {
+		return nil, err
+	}
+	return changelogURLTemplate, nil
+}
+
+func renderChangelogURL(changelogURLTemplate *template.Template, project lib_wip.Project, todo lib_wip.Todo) (string, error) {
+	var changelogURL strings.Builder
+	fields := changelogURLFields{TodoID: todo.ID, ProjectID: project.ID, ProjectSlug: project.Slug, ProjectName: project.Name}
+	if err := changelogURLTemplate.Execute(&changelogURL, fields); err != nil {
+		return "", err
+	}
+	rendered := strings.TrimSpace(changelogURL.String())
+	// Anything else wouldn't be linked, so it wouldn't cost TCO_URL_LENGTH like the tweet was fitted for
+	if !strings.HasPrefix(rendered, "https://") && !strings.HasPrefix(rendered, "http://") {
+		return "", fmt.Errorf("expected a URL starting with http:// or https://, got %q", rendered)
+	}
+	return rendered, nil
+}
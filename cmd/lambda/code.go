@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+)
+
+// A fenced code block, optionally with a language after the opening fence: ```go ... ```
+var fencedCodeBlockPattern = regexp.MustCompile("(?s)```([\\w+#.-]*)[ \\t]*\\n(.*?)\\n?```")
+
+type codeBlock struct {
+	Language string
+	Code     string
+}
+
+// Pulls up to maxBlocks fenced code blocks out of the body, leaving "(see image)" in their place. Any blocks after
+// that are left in the body as they are.
+func extractCodeBlocks(body string, maxBlocks int) (string, []codeBlock) {
+	matches := fencedCodeBlockPattern.FindAllStringSubmatchIndex(body, maxBlocks)
+	if len(matches) == 0 {
+		return body, nil
+	}
+
+	var codeBlocks []codeBlock
+	var extracted strings.Builder
+	previousEnd := 0
+	for i, match := range matches {
+		codeBlocks = append(codeBlocks, codeBlock{Language: body[match[2]:match[3]], Code: body[match[4]:match[5]]})
+		placeholder := "(see image)"
+		if len(matches) > 1 {
+			placeholder = fmt.Sprintf("(see image %d)", i+1)
+		}
+		extracted.WriteString(body[previousEnd:match[0]])
+		extracted.WriteString(placeholder)
+		previousEnd = match[1]
+	}
+	extracted.WriteString(body[previousEnd:])
+	return strings.TrimSpace(extracted.String()), codeBlocks
+}
+
+// Keeps the first maxEmoji emoji in the body like lib_text.LimitEmoji, but leaves fenced code blocks still in it as they
+// are. Emoji in the code aren't counted or removed.
+func limitEmojiOutsideCode(body string, maxEmoji int) string {
+	codeBlocks := fencedCodeBlockPattern.FindAllString(body, -1)
+	// Private use characters are never emoji, so the placeholders come through LimitEmoji untouched
+	placeholder := func(i int) string {
+		return fmt.Sprintf("\ue000%d\ue000", i)
+	}
+	i := 0
+	body = fencedCodeBlockPattern.ReplaceAllStringFunc(body, func(string) string {
+		i++
+		return placeholder(i - 1)
+	})
+	body = lib_text.LimitEmoji(body, maxEmoji)
+	for i, codeBlock := range codeBlocks {
+		body = strings.Replace(body, placeholder(i), codeBlock, 1)
+	}
+	return body
+}
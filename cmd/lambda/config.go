@@ -0,0 +1,723 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	lib_render "github.com/bakatz/wip-to-twitter-bridge/lib/render"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting for a run. Values come from the defaults below, then the file in CONFIG_FILE if there
+// is one, and then any evars that are set, so an evar always wins over the file.
+type Config struct {
+	WIPAPIKey string `yaml:"wip_api_key"`
+	// How the WIP key is sent: query (the api_key parameter, for personal API keys), bearer or token (for OAuth tokens), or a custom Authorization scheme
+	WIPAuthScheme string             `yaml:"wip_auth_scheme"`
+	Twitter       twitterCredentials `yaml:"twitter"`
+	// Todos from these project IDs are tweeted from their own accounts instead of the default one
+	ProjectTwitterCredentials map[string]twitterCredentials `yaml:"twitter_project_accounts"`
+
+	MaxAttachmentsPerTodo            int               `yaml:"max_attachments_per_todo"`
+	KeywordHashtags                  map[string]string `yaml:"keyword_hashtags"`
+	KeywordHashtagsFile              string            `yaml:"keyword_hashtags_file"`
+	ReplaceDefaultHashtag            bool              `yaml:"keyword_hashtags_replace_default"`
+	TextToImageOverflow              bool              `yaml:"text_to_image_overflow"`
+	TweetReplySettings               string            `yaml:"tweet_reply_settings"`
+	StripMetadata                    bool              `yaml:"strip_exif"`
+	MaxBodyLines                     int               `yaml:"max_body_lines"`
+	WindowGraceSeconds               int               `yaml:"window_grace_seconds"`
+	WIPCacheFile                     string            `yaml:"wip_cache_file"`
+	WIPCacheTTLMinutes               int               `yaml:"wip_cache_ttl_minutes"`
+	DiscordWebhookURL                string            `yaml:"discord_webhook_url"`
+	DailyStatsTweet                  bool              `yaml:"daily_stats_tweet"`
+	DailyStatsHour                   int               `yaml:"daily_stats_hour"`
+	SkipEmojiOnlyTodos               bool              `yaml:"skip_emoji_only_todos"`
+	AttachmentDownloadTimeoutSeconds int               `yaml:"attachment_download_timeout_seconds"`
+	AttachmentDownloadRetries        int               `yaml:"attachment_download_retries"`
+	// Used for time-of-day settings like DailyStatsHour
+	Timezone string `yaml:"timezone"`
+	// Where to remember tweeted todos between runs: memory (the default, remembers nothing), s3 or dynamodb
+	StateBackend  string `yaml:"state_backend"`
+	StateS3Bucket string `yaml:"state_s3_bucket"`
+	StateS3Key    string `yaml:"state_s3_key"`
+	// How long the S3 backend keeps processed records for
+	StateS3RetentionDays int    `yaml:"state_s3_retention_days"`
+	StateDynamoDBTable   string `yaml:"state_dynamodb_table"`
+	TwitterUserAgent     string `yaml:"twitter_user_agent"`
+	// Only makes sense with a persistent state backend, otherwise every run would be a first run
+	FirstRunSuppress      bool `yaml:"first_run_suppress"`
+	ExpandEmojiShortcodes bool `yaml:"expand_emoji_shortcodes"`
+	// Each project's todos reply to its previous tweet, so like FirstRunSuppress it needs a persistent state backend
+	ProjectThreads      bool `yaml:"project_threads"`
+	PollDurationMinutes int  `yaml:"poll_duration_minutes"`
+	// Only lets one run work at a time. Needs the DynamoDB state backend.
+	RunLock           bool   `yaml:"run_lock"`
+	RunLockName       string `yaml:"run_lock_name"`
+	RunLockTTLSeconds int    `yaml:"run_lock_ttl_seconds"`
+	// Locks each Twitter account separately, so only runs tweeting from the same account are kept apart
+	RunLockPerAccount bool `yaml:"run_lock_per_account"`
+	// Also tweet a translation of each todo, using a LibreTranslate compatible API
+	TranslateAPIURL         string `yaml:"translate_api_url"`
+	TranslateAPIKey         string `yaml:"translate_api_key"`
+	TranslateTargetLanguage string `yaml:"translate_target_language"`
+	TranslateMode           string `yaml:"translate_mode"`
+	// Quiet periods in the configured timezone, during which todos are deferred rather than tweeted
+	QuietHours   string `yaml:"quiet_hours"`
+	SkipWeekends bool   `yaml:"skip_weekends"`
+	// How similar (0-100) a todo can be to a recently tweeted one before it's skipped. 0 turns the check off.
+	NearDuplicateThreshold   int `yaml:"near_duplicate_threshold"`
+	NearDuplicateHistorySize int `yaml:"near_duplicate_history_size"`
+	// An intro like "Today's progress:" above the first tweet of each run
+	FirstTweetPrefix string `yaml:"first_tweet_prefix"`
+	// Where to write a JSON record of each run, like s3://my-bucket/runs/
+	RunManifestS3Prefix string `yaml:"run_manifest_s3_prefix"`
+	ShowProjectDomain   bool   `yaml:"show_project_domain"`
+	// Tweets consecutive short todos from the same project together, one line each
+	PackTodos bool `yaml:"pack_todos"`
+	// Tweets the body exactly as it is, without the checkmark, hashtags or footers
+	RawBody bool `yaml:"raw_body"`
+	// Waits for the rate limit window to reset once Twitter says this few calls are left in it
+	RateLimitMinRemaining int `yaml:"rate_limit_min_remaining"`
+	// Combines a todo's images into one grid image instead of attaching them separately
+	CompositeAttachments bool `yaml:"composite_attachments"`
+	// MIME type prefixes like image/ or application/pdf. Attachments of any other type are left off the tweet.
+	AllowedMediaTypes []string `yaml:"allowed_media_types"`
+	DeniedMediaTypes  []string `yaml:"denied_media_types"`
+	// Reports what the run would tweet, skip or filter without tweeting anything or changing the state store
+	DryRunDiff bool `yaml:"dry_run_diff"`
+	// When more todos than this are due to be tweeted in a run, they're tweeted as a thread instead. 0 turns this off.
+	BurstThreadThreshold int `yaml:"burst_thread_threshold"`
+	// Tweets the run's todos as replies to this tweet, e.g. to answer someone asking what's been shipped today
+	ReplyToTweetID string `yaml:"reply_to_tweet_id"`
+	// Signs outbound webhook payloads with an X-Signature header
+	WebhookSigningSecret string `yaml:"webhook_signing_secret"`
+	// Whether the hashtags go before (prefix) or after (suffix) the body
+	HashtagPosition string `yaml:"hashtag_position"`
+	// How many of each account's latest tweets to check todos against, so tweets posted by hand count as duplicates too. 0 turns this off.
+	TimelineDedupSize int `yaml:"timeline_dedup_size"`
+	// Goes between the checkmark and the body, like "Shipped:"
+	CompletionVerb string `yaml:"completion_verb"`
+	// How many times to rerun the fetch and post steps when they fail to connect to WIP or Twitter, e.g. on a cold start
+	RunRetries int `yaml:"run_retries"`
+	// Attaches fenced code blocks as syntax highlighted images instead of tweeting them as text
+	CodeToImage bool `yaml:"code_to_image"`
+	// Leaves todos alone for this long after they're completed, so there's time to fix a typo before they're tweeted
+	MinAgeBeforeTweetMinutes int `yaml:"min_age_before_tweet_minutes"`
+	// An image to attach in place of an attachment that fails to upload
+	FallbackAttachmentURL string `yaml:"fallback_attachment_url"`
+	// Only tweets todos from the project with this ID or name, ignoring every other project
+	ProjectFilter string `yaml:"project_filter"`
+	// Keeps the tweet to just its text and posts its attachments in replies to it instead
+	MediaAsReply bool `yaml:"media_as_reply"`
+	// The order to upload attachments in, so the one Twitter previews comes first: none, largest-first or marked-first
+	AttachmentSort string `yaml:"attachment_sort"`
+	// Only every nth run tweets, catching up on the todos the runs in between deferred. 0 or 1 tweets on every run.
+	TweetEveryNRuns int `yaml:"tweet_every_n_runs"`
+	// How similar (0-100) "Working on X" and a later "Finished X" have to be to be merged into one tweet. 0 turns this off.
+	ContinuationMergeThreshold int `yaml:"continuation_merge_threshold"`
+	// Asks on the terminal before each tweet, for careful manual runs. Only for running without Lambda.
+	Interactive bool `yaml:"interactive"`
+	// What to do with todos completed after now: wait, clamp or skip
+	FutureCompletionPolicy string `yaml:"future_completion_policy"`
+	// What to do with attachments over Twitter's size limit: fail, skip or link
+	OversizePolicy string `yaml:"oversize_policy"`
+	// Links each tweet to the todo's changelog entry, e.g. https://mysite.com/changelog#{{.TodoID}}
+	ChangelogURLTemplate string `yaml:"changelog_url_template"`
+	// Only the tweet that starts a thread gets hashtags, not the replies under it
+	HashtagOnThreadRootOnly bool `yaml:"hashtag_on_thread_root_only"`
+	// Tidies up line endings, trailing spaces and runs of blank lines in todo bodies before they're tweeted
+	NormalizeWhitespace bool `yaml:"normalize_whitespace"`
+	// Drops stray "..." or "-" a todo body trails off with, keeping ? and !
+	TrimTrailingPunctuation bool `yaml:"trim_trailing_punctuation"`
+	// Holds todos in the state store until they're approved with -approve, instead of tweeting them right away
+	ApprovalQueue bool `yaml:"approval_queue"`
+	// Start the log lines for tweeted, skipped and failed todos so they're easy to tell apart. Empty leaves them off.
+	StatusEmojiTweeted string `yaml:"status_emoji_tweeted"`
+	StatusEmojiSkipped string `yaml:"status_emoji_skipped"`
+	StatusEmojiFailed  string `yaml:"status_emoji_failed"`
+	// Once a week, on WeeklyRecapDay at WeeklyRecapHour, tweets a thread of the week's tweeted todos grouped by project
+	WeeklyRecap     bool   `yaml:"weekly_recap"`
+	WeeklyRecapDay  string `yaml:"weekly_recap_day"`
+	WeeklyRecapHour int    `yaml:"weekly_recap_hour"`
+	// What to do about a week with nothing tweeted: skip the recap or post that it was a quiet week
+	WeeklyRecapQuietWeek string `yaml:"weekly_recap_quiet_week"`
+	// What to do with todos that are only emoji or only links: tweet, skip or augment them with the project's name
+	LowContentPolicy string `yaml:"low_content_policy"`
+	// Status codes and message fragments of errors that RunRetries retries on top of connection errors
+	RetryableErrors []string `yaml:"retryable_errors"`
+	// The most retries a run makes in total, across run attempts and attachment downloads. 0 means no budget.
+	RunRetryBudget int `yaml:"run_retry_budget"`
+	// Draws WatermarkTemplate onto image attachments before they're uploaded
+	WatermarkAttachments bool   `yaml:"watermark_attachments"`
+	WatermarkTemplate    string `yaml:"watermark_template"`
+	// top-left, top-right, bottom-left or bottom-right
+	WatermarkPosition string `yaml:"watermark_position"`
+	// regular, bold, mono or the path to a font file
+	WatermarkFont string `yaml:"watermark_font"`
+	// 0 scales the text with the image
+	WatermarkFontSize int `yaml:"watermark_font_size"`
+	// Reuses the media ID of an attachment already uploaded this run when another one has the same contents
+	DedupAttachmentsByHash bool `yaml:"dedup_attachments_by_hash"`
+	// POSTs a JSON payload for each todo that goes out, for automation tools like Zapier, Make or n8n
+	OutboundWebhookURL string `yaml:"outbound_webhook_url"`
+	// Added to the end of each tweet that has room for it. true adds DEFAULT_BOT_DISCLOSURE.
+	BotDisclosure string `yaml:"bot_disclosure"`
+	// Keeps only the first n emoji in each todo body. 0 means no limit.
+	MaxEmoji int `yaml:"max_emoji"`
+	// Defers a todo whose attachment is still processing for RequeueDelayMinutes, rather than failing it
+	RequeueProcessingAttachments bool `yaml:"requeue_processing_attachments"`
+	RequeueDelayMinutes          int  `yaml:"requeue_delay_minutes"`
+	// Daily times like "09:00" in the configured timezone. Todos are queued for the next one rather than tweeted right away.
+	PostingTimes []string `yaml:"posting_times"`
+	// Only posts the queued todos that are due, leaving new todos for the runs that queue them
+	DrainQueue bool `yaml:"drain_queue"`
+
+	// Worked out from the settings above by validate
+	keywordHashtags      []keywordHashtag
+	defaultReplySettings string
+	location             *time.Location
+	quietHours           *quietHours
+	postingTimes         []int
+	weeklyRecapDay       time.Weekday
+	mediaTypes           mediaTypeFilter
+	changelogURLTemplate *template.Template
+	isRetryable          retryClassifier
+	watermarkTemplate    *template.Template
+	watermarkOptions     lib_render.WatermarkOptions
+	botDisclosure        string
+}
+
+func defaultConfig() Config {
+	return Config{
+		MaxAttachmentsPerTodo: DEFAULT_MAX_ATTACHMENTS_PER_TODO,
+		// Stripping image metadata is a privacy safeguard, so it's on unless explicitly turned off
+		StripMetadata:                    true,
+		WIPAuthScheme:                    lib_wip.AUTH_SCHEME_QUERY,
+		WIPCacheTTLMinutes:               DEFAULT_WIP_CACHE_TTL_MINUTES,
+		DailyStatsHour:                   DEFAULT_DAILY_STATS_HOUR,
+		AttachmentDownloadTimeoutSeconds: DEFAULT_ATTACHMENT_DOWNLOAD_TIMEOUT_SECONDS,
+		AttachmentDownloadRetries:        DEFAULT_ATTACHMENT_DOWNLOAD_RETRIES,
+		StateBackend:                     STATE_BACKEND_MEMORY,
+		StateS3Key:                       DEFAULT_STATE_S3_KEY,
+		StateS3RetentionDays:             DEFAULT_STATE_S3_RETENTION_DAYS,
+		TwitterUserAgent:                 DEFAULT_TWITTER_USER_AGENT,
+		PollDurationMinutes:              DEFAULT_POLL_DURATION_MINUTES,
+		RunLockName:                      DEFAULT_RUN_LOCK_NAME,
+		RunLockTTLSeconds:                DEFAULT_RUN_LOCK_TTL_SECONDS,
+		TranslateMode:                    TRANSLATE_MODE_REPLY,
+		NearDuplicateHistorySize:         DEFAULT_NEAR_DUPLICATE_HISTORY_SIZE,
+		AllowedMediaTypes:                DEFAULT_ALLOWED_MEDIA_TYPES,
+		HashtagPosition:                  HASHTAG_POSITION_SUFFIX,
+		AttachmentSort:                   ATTACHMENT_SORT_NONE,
+		FutureCompletionPolicy:           FUTURE_COMPLETION_POLICY_WAIT,
+		OversizePolicy:                   OVERSIZE_POLICY_FAIL,
+		StatusEmojiTweeted:               DEFAULT_STATUS_EMOJI_TWEETED,
+		StatusEmojiSkipped:               DEFAULT_STATUS_EMOJI_SKIPPED,
+		StatusEmojiFailed:                DEFAULT_STATUS_EMOJI_FAILED,
+		WeeklyRecapDay:                   DEFAULT_WEEKLY_RECAP_DAY,
+		WeeklyRecapHour:                  DEFAULT_WEEKLY_RECAP_HOUR,
+		WeeklyRecapQuietWeek:             WEEKLY_RECAP_QUIET_SKIP,
+		LowContentPolicy:                 LOW_CONTENT_POLICY_TWEET,
+		RetryableErrors:                  DEFAULT_RETRYABLE_ERRORS,
+		WatermarkTemplate:                DEFAULT_WATERMARK_TEMPLATE,
+		WatermarkPosition:                lib_render.WATERMARK_POSITION_BOTTOM_RIGHT,
+		WatermarkFont:                    DEFAULT_WATERMARK_FONT,
+		RequeueDelayMinutes:              DEFAULT_REQUEUE_DELAY_MINUTES,
+	}
+}
+
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		configBytes, err := os.ReadFile(configFile)
+		if err != nil {
+			return cfg, fmt.Errorf("could not read CONFIG_FILE: %w", err)
+		}
+		// JSON is valid YAML, so this handles both kinds of file
+		if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
+			return cfg, fmt.Errorf("could not parse CONFIG_FILE as YAML or JSON: %w", err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Overrides the settings with any evars that are set
+func (cfg *Config) applyEnv() error {
+	envString(&cfg.WIPAPIKey, "WIP_API_KEY")
+	envString(&cfg.WIPAuthScheme, "WIP_AUTH_SCHEME")
+	envString(&cfg.Twitter.APIKey, "TWITTER_API_KEY")
+	envString(&cfg.Twitter.APIKeySecret, "TWITTER_API_KEY_SECRET")
+	envString(&cfg.Twitter.AccessToken, "TWITTER_ACCESS_TOKEN")
+	envString(&cfg.Twitter.AccessTokenSecret, "TWITTER_ACCESS_TOKEN_SECRET")
+	envString(&cfg.KeywordHashtagsFile, "KEYWORD_HASHTAGS_FILE")
+	envString(&cfg.TweetReplySettings, "TWEET_REPLY_SETTINGS")
+	envString(&cfg.WIPCacheFile, "WIP_CACHE_FILE")
+	envString(&cfg.DiscordWebhookURL, "DISCORD_WEBHOOK_URL")
+	envString(&cfg.Timezone, "TIMEZONE")
+	envString(&cfg.StateBackend, "STATE_BACKEND")
+	envString(&cfg.StateS3Bucket, "STATE_S3_BUCKET")
+	envString(&cfg.StateS3Key, "STATE_S3_KEY")
+	envString(&cfg.StateDynamoDBTable, "STATE_DYNAMODB_TABLE")
+	envString(&cfg.TwitterUserAgent, "TWITTER_USER_AGENT")
+	envString(&cfg.RunLockName, "RUN_LOCK_NAME")
+	envString(&cfg.TranslateAPIURL, "TRANSLATE_API_URL")
+	envString(&cfg.TranslateAPIKey, "TRANSLATE_API_KEY")
+	envString(&cfg.TranslateTargetLanguage, "TRANSLATE_TARGET_LANGUAGE")
+	envString(&cfg.TranslateMode, "TRANSLATE_MODE")
+	envString(&cfg.QuietHours, "QUIET_HOURS")
+	envString(&cfg.FirstTweetPrefix, "FIRST_TWEET_PREFIX")
+	envString(&cfg.RunManifestS3Prefix, "RUN_MANIFEST_S3_PREFIX")
+	envString(&cfg.WebhookSigningSecret, "WEBHOOK_SIGNING_SECRET")
+	envString(&cfg.HashtagPosition, "HASHTAG_POSITION")
+	envString(&cfg.CompletionVerb, "COMPLETION_VERB")
+	envString(&cfg.FallbackAttachmentURL, "FALLBACK_ATTACHMENT_URL")
+	envString(&cfg.ProjectFilter, "PROJECT_FILTER")
+	envString(&cfg.ReplyToTweetID, "REPLY_TO_TWEET_ID")
+	envString(&cfg.AttachmentSort, "ATTACHMENT_SORT")
+	envString(&cfg.FutureCompletionPolicy, "FUTURE_COMPLETION_POLICY")
+	envString(&cfg.OversizePolicy, "OVERSIZE_POLICY")
+	envString(&cfg.ChangelogURLTemplate, "CHANGELOG_URL_TEMPLATE")
+	envString(&cfg.StatusEmojiTweeted, "STATUS_EMOJI_TWEETED")
+	envString(&cfg.StatusEmojiSkipped, "STATUS_EMOJI_SKIPPED")
+	envString(&cfg.StatusEmojiFailed, "STATUS_EMOJI_FAILED")
+	envString(&cfg.WeeklyRecapDay, "WEEKLY_RECAP_DAY")
+	envString(&cfg.WeeklyRecapQuietWeek, "WEEKLY_RECAP_QUIET_WEEK")
+	envString(&cfg.LowContentPolicy, "LOW_CONTENT_POLICY")
+	envString(&cfg.WatermarkTemplate, "WATERMARK_TEMPLATE")
+	envString(&cfg.WatermarkPosition, "WATERMARK_POSITION")
+	envString(&cfg.WatermarkFont, "WATERMARK_FONT")
+	envString(&cfg.OutboundWebhookURL, "OUTBOUND_WEBHOOK_URL")
+	envString(&cfg.BotDisclosure, "BOT_DISCLOSURE")
+	envList(&cfg.AllowedMediaTypes, "ALLOWED_MEDIA_TYPES")
+	envList(&cfg.DeniedMediaTypes, "DENIED_MEDIA_TYPES")
+	envList(&cfg.RetryableErrors, "RETRYABLE_ERRORS")
+	envList(&cfg.PostingTimes, "POSTING_TIMES")
+
+	for name, value := range map[string]*bool{
+		"KEYWORD_HASHTAGS_REPLACE_DEFAULT": &cfg.ReplaceDefaultHashtag,
+		"TEXT_TO_IMAGE_OVERFLOW":           &cfg.TextToImageOverflow,
+		"STRIP_EXIF":                       &cfg.StripMetadata,
+		"DAILY_STATS_TWEET":                &cfg.DailyStatsTweet,
+		"SKIP_EMOJI_ONLY_TODOS":            &cfg.SkipEmojiOnlyTodos,
+		"FIRST_RUN_SUPPRESS":               &cfg.FirstRunSuppress,
+		"EXPAND_EMOJI_SHORTCODES":          &cfg.ExpandEmojiShortcodes,
+		"PROJECT_THREADS":                  &cfg.ProjectThreads,
+		"RUN_LOCK":                         &cfg.RunLock,
+		"RUN_LOCK_PER_ACCOUNT":             &cfg.RunLockPerAccount,
+		"SKIP_WEEKENDS":                    &cfg.SkipWeekends,
+		"SHOW_PROJECT_DOMAIN":              &cfg.ShowProjectDomain,
+		"PACK_TODOS":                       &cfg.PackTodos,
+		"RAW_BODY":                         &cfg.RawBody,
+		"COMPOSITE_ATTACHMENTS":            &cfg.CompositeAttachments,
+		"DRY_RUN_DIFF":                     &cfg.DryRunDiff,
+		"CODE_TO_IMAGE":                    &cfg.CodeToImage,
+		"MEDIA_AS_REPLY":                   &cfg.MediaAsReply,
+		"INTERACTIVE":                      &cfg.Interactive,
+		"HASHTAG_ON_THREAD_ROOT_ONLY":      &cfg.HashtagOnThreadRootOnly,
+		"NORMALIZE_WHITESPACE":             &cfg.NormalizeWhitespace,
+		"TRIM_TRAILING_PUNCTUATION":        &cfg.TrimTrailingPunctuation,
+		"APPROVAL_QUEUE":                   &cfg.ApprovalQueue,
+		"WEEKLY_RECAP":                     &cfg.WeeklyRecap,
+		"WATERMARK_ATTACHMENTS":            &cfg.WatermarkAttachments,
+		"DEDUP_ATTACHMENTS_BY_HASH":        &cfg.DedupAttachmentsByHash,
+		"REQUEUE_PROCESSING_ATTACHMENTS":   &cfg.RequeueProcessingAttachments,
+		"DRAIN_QUEUE":                      &cfg.DrainQueue,
+	} {
+		if err := envBool(value, name); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range map[string]*int{
+		"MAX_ATTACHMENTS_PER_TODO":            &cfg.MaxAttachmentsPerTodo,
+		"MAX_BODY_LINES":                      &cfg.MaxBodyLines,
+		"WINDOW_GRACE_SECONDS":                &cfg.WindowGraceSeconds,
+		"WIP_CACHE_TTL_MINUTES":               &cfg.WIPCacheTTLMinutes,
+		"DAILY_STATS_HOUR":                    &cfg.DailyStatsHour,
+		"STATE_S3_RETENTION_DAYS":             &cfg.StateS3RetentionDays,
+		"ATTACHMENT_DOWNLOAD_TIMEOUT_SECONDS": &cfg.AttachmentDownloadTimeoutSeconds,
+		"ATTACHMENT_DOWNLOAD_RETRIES":         &cfg.AttachmentDownloadRetries,
+		"POLL_DURATION_MINUTES":               &cfg.PollDurationMinutes,
+		"RUN_LOCK_TTL_SECONDS":                &cfg.RunLockTTLSeconds,
+		"NEAR_DUPLICATE_THRESHOLD":            &cfg.NearDuplicateThreshold,
+		"NEAR_DUPLICATE_HISTORY_SIZE":         &cfg.NearDuplicateHistorySize,
+		"RATE_LIMIT_MIN_REMAINING":            &cfg.RateLimitMinRemaining,
+		"BURST_THREAD_THRESHOLD":              &cfg.BurstThreadThreshold,
+		"TIMELINE_DEDUP_SIZE":                 &cfg.TimelineDedupSize,
+		"RUN_RETRIES":                         &cfg.RunRetries,
+		"MIN_AGE_BEFORE_TWEET_MINUTES":        &cfg.MinAgeBeforeTweetMinutes,
+		"TWEET_EVERY_N_RUNS":                  &cfg.TweetEveryNRuns,
+		"CONTINUATION_MERGE_THRESHOLD":        &cfg.ContinuationMergeThreshold,
+		"WEEKLY_RECAP_HOUR":                   &cfg.WeeklyRecapHour,
+		"WATERMARK_FONT_SIZE":                 &cfg.WatermarkFontSize,
+		"MAX_EMOJI":                           &cfg.MaxEmoji,
+		"RUN_RETRY_BUDGET":                    &cfg.RunRetryBudget,
+		"REQUEUE_DELAY_MINUTES":               &cfg.RequeueDelayMinutes,
+	} {
+		if err := envInt(value, name); err != nil {
+			return err
+		}
+	}
+
+	if keywordHashtags := os.Getenv("KEYWORD_HASHTAGS"); keywordHashtags != "" {
+		if err := json.Unmarshal([]byte(keywordHashtags), &cfg.KeywordHashtags); err != nil {
+			return fmt.Errorf("KEYWORD_HASHTAGS must be a JSON object mapping keywords to hashtags")
+		}
+	}
+	if projectAccounts := os.Getenv("TWITTER_PROJECT_ACCOUNTS"); projectAccounts != "" {
+		if err := json.Unmarshal([]byte(projectAccounts), &cfg.ProjectTwitterCredentials); err != nil {
+			return fmt.Errorf("TWITTER_PROJECT_ACCOUNTS must be a JSON object mapping project IDs to Twitter credentials")
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.MaxAttachmentsPerTodo < 0 {
+		return fmt.Errorf("MAX_ATTACHMENTS_PER_TODO must be a non-negative integer")
+	}
+	if cfg.MaxBodyLines < 0 {
+		return fmt.Errorf("MAX_BODY_LINES must be a non-negative integer")
+	}
+	if cfg.WindowGraceSeconds < 0 {
+		return fmt.Errorf("WINDOW_GRACE_SECONDS must be a non-negative integer")
+	}
+	if cfg.WIPCacheTTLMinutes < 0 {
+		return fmt.Errorf("WIP_CACHE_TTL_MINUTES must be a non-negative integer")
+	}
+	if cfg.DailyStatsHour < 0 || cfg.DailyStatsHour > 23 {
+		return fmt.Errorf("DAILY_STATS_HOUR must be an hour between 0 and 23")
+	}
+	if cfg.AttachmentDownloadTimeoutSeconds <= 0 {
+		return fmt.Errorf("ATTACHMENT_DOWNLOAD_TIMEOUT_SECONDS must be a positive integer")
+	}
+	if cfg.AttachmentDownloadRetries < 0 {
+		return fmt.Errorf("ATTACHMENT_DOWNLOAD_RETRIES must be a non-negative integer")
+	}
+	if cfg.PollDurationMinutes < MIN_POLL_DURATION_MINUTES || cfg.PollDurationMinutes > MAX_POLL_DURATION_MINUTES {
+		return fmt.Errorf("POLL_DURATION_MINUTES must be between %d and %d", MIN_POLL_DURATION_MINUTES, MAX_POLL_DURATION_MINUTES)
+	}
+	switch cfg.StateBackend {
+	case STATE_BACKEND_MEMORY:
+	case STATE_BACKEND_S3:
+		if cfg.StateS3Bucket == "" {
+			return fmt.Errorf("STATE_S3_BUCKET is required when STATE_BACKEND is s3")
+		}
+		// The daily stats count the day's records
+		if cfg.StateS3RetentionDays < 1 {
+			return fmt.Errorf("STATE_S3_RETENTION_DAYS must be at least 1")
+		}
+	case STATE_BACKEND_DYNAMODB:
+		if cfg.StateDynamoDBTable == "" {
+			return fmt.Errorf("STATE_DYNAMODB_TABLE is required when STATE_BACKEND is dynamodb")
+		}
+	default:
+		return fmt.Errorf("STATE_BACKEND must be one of memory, s3 or dynamodb")
+	}
+	if cfg.FirstRunSuppress && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("FIRST_RUN_SUPPRESS needs STATE_BACKEND to be s3 or dynamodb")
+	}
+	if cfg.ProjectThreads && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("PROJECT_THREADS needs STATE_BACKEND to be s3 or dynamodb")
+	}
+	if cfg.RunLock && cfg.StateBackend != STATE_BACKEND_DYNAMODB {
+		return fmt.Errorf("RUN_LOCK needs STATE_BACKEND to be dynamodb")
+	}
+	if cfg.RunLockPerAccount && !cfg.RunLock {
+		return fmt.Errorf("RUN_LOCK_PER_ACCOUNT needs RUN_LOCK to be true")
+	}
+	if cfg.RunLockTTLSeconds <= 0 {
+		return fmt.Errorf("RUN_LOCK_TTL_SECONDS must be a positive integer")
+	}
+	if cfg.TranslateAPIURL != "" && cfg.TranslateTargetLanguage == "" {
+		return fmt.Errorf("TRANSLATE_TARGET_LANGUAGE is required when TRANSLATE_API_URL is set")
+	}
+	if cfg.TranslateMode != TRANSLATE_MODE_REPLY && cfg.TranslateMode != TRANSLATE_MODE_SEPARATE {
+		return fmt.Errorf("TRANSLATE_MODE must be either reply or separate")
+	}
+	if (cfg.QuietHours != "" || cfg.SkipWeekends) && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("QUIET_HOURS and SKIP_WEEKENDS need STATE_BACKEND to be s3 or dynamodb to defer todos")
+	}
+	if cfg.ContinuationMergeThreshold < 0 || cfg.ContinuationMergeThreshold > 100 {
+		return fmt.Errorf("CONTINUATION_MERGE_THRESHOLD must be between 0 and 100")
+	}
+	switch cfg.FutureCompletionPolicy {
+	case FUTURE_COMPLETION_POLICY_WAIT:
+	case FUTURE_COMPLETION_POLICY_CLAMP, FUTURE_COMPLETION_POLICY_SKIP:
+		// The todo's own window comes round later, and only the state store stops that run tweeting it again
+		if cfg.StateBackend == STATE_BACKEND_MEMORY {
+			return fmt.Errorf("FUTURE_COMPLETION_POLICY %s needs STATE_BACKEND to be s3 or dynamodb", cfg.FutureCompletionPolicy)
+		}
+	default:
+		return fmt.Errorf("FUTURE_COMPLETION_POLICY must be one of wait, clamp or skip")
+	}
+	switch cfg.OversizePolicy {
+	case OVERSIZE_POLICY_FAIL, OVERSIZE_POLICY_SKIP, OVERSIZE_POLICY_LINK:
+	default:
+		return fmt.Errorf("OVERSIZE_POLICY must be one of fail, skip or link")
+	}
+	if cfg.Interactive && !isRunningWithoutLambda() {
+		return fmt.Errorf("INTERACTIVE needs RUN_WITHOUT_LAMBDA to be true, there's no one to answer in Lambda")
+	}
+	if cfg.TweetEveryNRuns < 0 {
+		return fmt.Errorf("TWEET_EVERY_N_RUNS must be a non-negative integer")
+	}
+	if cfg.TweetEveryNRuns > 1 && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("TWEET_EVERY_N_RUNS needs STATE_BACKEND to be s3 or dynamodb to count runs and defer todos")
+	}
+	if cfg.RequeueProcessingAttachments && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("REQUEUE_PROCESSING_ATTACHMENTS needs STATE_BACKEND to be s3 or dynamodb to defer todos")
+	}
+	if cfg.RequeueDelayMinutes < 0 {
+		return fmt.Errorf("REQUEUE_DELAY_MINUTES must be a non-negative integer")
+	}
+	if len(cfg.PostingTimes) > 0 && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("POSTING_TIMES needs STATE_BACKEND to be s3 or dynamodb to queue todos")
+	}
+	if cfg.DrainQueue && len(cfg.PostingTimes) == 0 {
+		return fmt.Errorf("DRAIN_QUEUE needs POSTING_TIMES, there's no queue to drain without it")
+	}
+	if cfg.WeeklyRecapHour < 0 || cfg.WeeklyRecapHour > 23 {
+		return fmt.Errorf("WEEKLY_RECAP_HOUR must be between 0 and 23")
+	}
+	switch cfg.WatermarkPosition {
+	case lib_render.WATERMARK_POSITION_TOP_LEFT, lib_render.WATERMARK_POSITION_TOP_RIGHT, lib_render.WATERMARK_POSITION_BOTTOM_LEFT, lib_render.WATERMARK_POSITION_BOTTOM_RIGHT:
+	default:
+		return fmt.Errorf("WATERMARK_POSITION must be one of top-left, top-right, bottom-left or bottom-right")
+	}
+	if cfg.RunRetryBudget < 0 {
+		return fmt.Errorf("RUN_RETRY_BUDGET must be a non-negative integer")
+	}
+	if cfg.MaxEmoji < 0 {
+		return fmt.Errorf("MAX_EMOJI must be a non-negative integer")
+	}
+	if cfg.WatermarkFontSize < 0 {
+		return fmt.Errorf("WATERMARK_FONT_SIZE must be a non-negative integer")
+	}
+	switch cfg.LowContentPolicy {
+	case LOW_CONTENT_POLICY_TWEET, LOW_CONTENT_POLICY_SKIP, LOW_CONTENT_POLICY_AUGMENT:
+	default:
+		return fmt.Errorf("LOW_CONTENT_POLICY must be one of tweet, skip or augment")
+	}
+	switch cfg.WeeklyRecapQuietWeek {
+	case WEEKLY_RECAP_QUIET_SKIP, WEEKLY_RECAP_QUIET_POST:
+	default:
+		return fmt.Errorf("WEEKLY_RECAP_QUIET_WEEK must be one of skip or post")
+	}
+	if cfg.DailyStatsTweet && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("DAILY_STATS_TWEET needs STATE_BACKEND to be s3 or dynamodb to count the day's tweeted todos")
+	}
+	if cfg.WeeklyRecap && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("WEEKLY_RECAP needs STATE_BACKEND to be s3 or dynamodb to remember the week's tweeted todos")
+	}
+	if cfg.ApprovalQueue && cfg.StateBackend == STATE_BACKEND_MEMORY {
+		return fmt.Errorf("APPROVAL_QUEUE needs STATE_BACKEND to be s3 or dynamodb to keep todos until they're approved")
+	}
+	if cfg.NearDuplicateThreshold < 0 || cfg.NearDuplicateThreshold > 100 {
+		return fmt.Errorf("NEAR_DUPLICATE_THRESHOLD must be between 0 and 100")
+	}
+	if cfg.NearDuplicateHistorySize <= 0 {
+		return fmt.Errorf("NEAR_DUPLICATE_HISTORY_SIZE must be a positive integer")
+	}
+	cfg.isRetryable = newRetryClassifier(cfg.RetryableErrors)
+	cfg.mediaTypes = mediaTypeFilter{allowed: normalizeMediaTypes(cfg.AllowedMediaTypes), denied: normalizeMediaTypes(cfg.DeniedMediaTypes)}
+	switch cfg.AttachmentSort {
+	case ATTACHMENT_SORT_NONE, ATTACHMENT_SORT_LARGEST_FIRST, ATTACHMENT_SORT_MARKED_FIRST:
+	default:
+		return fmt.Errorf("ATTACHMENT_SORT must be one of none, largest-first or marked-first")
+	}
+	if cfg.HashtagPosition != HASHTAG_POSITION_PREFIX && cfg.HashtagPosition != HASHTAG_POSITION_SUFFIX {
+		return fmt.Errorf("HASHTAG_POSITION must be either prefix or suffix")
+	}
+	if cfg.TimelineDedupSize != 0 && (cfg.TimelineDedupSize < MIN_TIMELINE_DEDUP_SIZE || cfg.TimelineDedupSize > MAX_TIMELINE_DEDUP_SIZE) {
+		return fmt.Errorf("TIMELINE_DEDUP_SIZE must be 0 or between %d and %d", MIN_TIMELINE_DEDUP_SIZE, MAX_TIMELINE_DEDUP_SIZE)
+	}
+	if cfg.MinAgeBeforeTweetMinutes < 0 {
+		return fmt.Errorf("MIN_AGE_BEFORE_TWEET_MINUTES must be a non-negative integer")
+	}
+	if cfg.RunRetries < 0 {
+		return fmt.Errorf("RUN_RETRIES must be a non-negative integer")
+	}
+	if cfg.BurstThreadThreshold < 0 {
+		return fmt.Errorf("BURST_THREAD_THRESHOLD must be a non-negative integer")
+	}
+	if cfg.BurstThreadThreshold > 0 && cfg.ProjectThreads {
+		return fmt.Errorf("BURST_THREAD_THRESHOLD can't be combined with PROJECT_THREADS, which already threads every todo")
+	}
+	if cfg.ReplyToTweetID != "" {
+		if _, err := strconv.ParseUint(cfg.ReplyToTweetID, 10, 64); err != nil {
+			return fmt.Errorf("REPLY_TO_TWEET_ID must be a tweet ID, got %q", cfg.ReplyToTweetID)
+		}
+		if cfg.ProjectThreads || cfg.BurstThreadThreshold > 0 {
+			return fmt.Errorf("REPLY_TO_TWEET_ID can't be combined with PROJECT_THREADS or BURST_THREAD_THRESHOLD, which thread the todos their own way")
+		}
+	}
+	if cfg.RateLimitMinRemaining < 0 {
+		return fmt.Errorf("RATE_LIMIT_MIN_REMAINING can't be negative")
+	}
+	if cfg.RawBody && (cfg.FirstTweetPrefix != "" || cfg.PackTodos || cfg.CompletionVerb != "") {
+		return fmt.Errorf("RAW_BODY can't be combined with FIRST_TWEET_PREFIX, PACK_TODOS or COMPLETION_VERB, which decorate the tweet")
+	}
+	if cfg.CompletionVerb = strings.TrimSpace(cfg.CompletionVerb); cfg.CompletionVerb != "" {
+		cfg.CompletionVerb += " "
+	}
+	cfg.FirstTweetPrefix = strings.TrimSpace(cfg.FirstTweetPrefix)
+	if tweetLength(cfg.FirstTweetPrefix) > MAX_FIRST_TWEET_PREFIX_LENGTH {
+		return fmt.Errorf("FIRST_TWEET_PREFIX must be at most %d characters so there's room left for the todo", MAX_FIRST_TWEET_PREFIX_LENGTH)
+	}
+	if cfg.RunManifestS3Prefix != "" {
+		if _, _, err := parseS3Prefix(cfg.RunManifestS3Prefix); err != nil {
+			return fmt.Errorf("RUN_MANIFEST_S3_PREFIX must look like s3://my-bucket/runs/: %w", err)
+		}
+	}
+	cfg.WIPAPIKey = strings.TrimSpace(cfg.WIPAPIKey)
+	if err := lib_wip.ValidateAuthScheme(cfg.WIPAuthScheme); err != nil {
+		return fmt.Errorf("WIP_AUTH_SCHEME must be query, bearer, token or a custom single word scheme: %w", err)
+	}
+	cfg.Twitter = cfg.Twitter.trimmed()
+	for projectID, credentials := range cfg.ProjectTwitterCredentials {
+		cfg.ProjectTwitterCredentials[projectID] = credentials.trimmed()
+	}
+	if len(cfg.ProjectTwitterCredentials) > 0 && !cfg.twitterEnabled() {
+		return fmt.Errorf("TWITTER_PROJECT_ACCOUNTS needs the default TWITTER_* credentials to be set too")
+	}
+	for projectID, credentials := range cfg.ProjectTwitterCredentials {
+		if err := credentials.validate(); err != nil {
+			return fmt.Errorf("TWITTER_PROJECT_ACCOUNTS has invalid credentials for project %s: %w", projectID, err)
+		}
+	}
+
+	var err error
+	// Keyword hashtags are off unless a mapping is configured
+	cfg.keywordHashtags, err = loadKeywordHashtags(cfg.KeywordHashtags, cfg.KeywordHashtagsFile)
+	if err != nil {
+		return fmt.Errorf("KEYWORD_HASHTAGS or KEYWORD_HASHTAGS_FILE must map keywords to hashtags: %w", err)
+	}
+
+	cfg.defaultReplySettings, err = parseReplySettings(cfg.TweetReplySettings)
+	if err != nil {
+		return fmt.Errorf("TWEET_REPLY_SETTINGS must be one of everyone, mentioned or following")
+	}
+
+	cfg.location, err = time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("TIMEZONE must be an IANA timezone name like America/New_York")
+	}
+
+	cfg.weeklyRecapDay, err = parseWeekday(cfg.WeeklyRecapDay)
+	if err != nil {
+		return fmt.Errorf("WEEKLY_RECAP_DAY must be a day of the week like sunday")
+	}
+
+	// Changelog links are off unless a template is configured
+	if cfg.ChangelogURLTemplate != "" {
+		cfg.changelogURLTemplate, err = parseChangelogURLTemplate(cfg.ChangelogURLTemplate)
+		if err != nil {
+			return fmt.Errorf("CHANGELOG_URL_TEMPLATE must be a template using .TodoID, .ProjectID, .ProjectSlug or .ProjectName: %w", err)
+		}
+	}
+
+	switch strings.TrimSpace(cfg.BotDisclosure) {
+	case "true":
+		cfg.botDisclosure = DEFAULT_BOT_DISCLOSURE
+	case "false":
+		cfg.botDisclosure = ""
+	default:
+		cfg.botDisclosure = strings.TrimSpace(cfg.BotDisclosure)
+	}
+
+	if cfg.WatermarkAttachments {
+		cfg.watermarkTemplate, err = parseWatermarkTemplate(cfg.WatermarkTemplate)
+		if err != nil {
+			return fmt.Errorf("WATERMARK_TEMPLATE must be a template using .ProjectName, .ProjectSlug, .TodoID or .CompletedAt: %w", err)
+		}
+		cfg.watermarkOptions = lib_render.DefaultWatermarkOptions()
+		cfg.watermarkOptions.Position = cfg.WatermarkPosition
+		cfg.watermarkOptions.FontSize = float64(cfg.WatermarkFontSize)
+		cfg.watermarkOptions.Font, err = loadWatermarkFont(cfg.WatermarkFont)
+		if err != nil {
+			return fmt.Errorf("WATERMARK_FONT must be regular, bold, mono or the path to a font file: %w", err)
+		}
+		if _, err := lib_render.NewFaceFrom(cfg.watermarkOptions.Font, 12); err != nil {
+			return fmt.Errorf("WATERMARK_FONT must be regular, bold, mono or the path to a font file: %w", err)
+		}
+	}
+
+	// Quiet hours are off unless a period is configured
+	if cfg.QuietHours != "" {
+		cfg.quietHours, err = parseQuietHours(cfg.QuietHours)
+		if err != nil {
+			return fmt.Errorf("QUIET_HOURS must be a period like 22:00-07:00: %w", err)
+		}
+	}
+
+	cfg.postingTimes, err = parsePostingTimes(cfg.PostingTimes)
+	if err != nil {
+		return fmt.Errorf("POSTING_TIMES must be a comma separated list of times like 09:00,18:00: %w", err)
+	}
+	return nil
+}
+
+// Whether todos can be deferred, in which case runs that tweet have to catch up on them
+func (cfg Config) defersTodos() bool {
+	return cfg.SkipWeekends || cfg.quietHours != nil || cfg.TweetEveryNRuns > 1 || cfg.RequeueProcessingAttachments || len(cfg.postingTimes) > 0
+}
+
+// Twitter can be left out entirely when todos only go to another platform, like Discord
+func (cfg Config) twitterEnabled() bool {
+	return !cfg.Twitter.isEmpty()
+}
+
+func envString(value *string, name string) {
+	if envValue := os.Getenv(name); envValue != "" {
+		*value = envValue
+	}
+}
+
+// Comma separated, e.g. "image/,video/mp4"
+func envList(value *[]string, name string) {
+	if envValue := os.Getenv(name); envValue != "" {
+		*value = strings.Split(envValue, ",")
+	}
+}
+
+func envBool(value *bool, name string) error {
+	envValue := os.Getenv(name)
+	if envValue == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false", name)
+	}
+	*value = parsed
+	return nil
+}
+
+func envInt(value *int, name string) error {
+	envValue := os.Getenv(name)
+	if envValue == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(envValue)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer", name)
+	}
+	*value = parsed
+	return nil
+}
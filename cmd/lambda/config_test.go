@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+// Clears the evars the tests set, so they start from nothing and are put back afterwards
+func clearConfigEnv(t *testing.T) {
+	for _, key := range []string{"CONFIG_FILE", "PROJECT_FILTER", "RUN_RETRIES", "REPLY_TO_TWEET_ID"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	configFile := writeConfigFile(t, "project_filter: from-file\nrun_retries: 1\nreply_to_tweet_id: \"111\"\n")
+	tests := []struct {
+		name             string
+		env              map[string]string
+		flags            map[string]string
+		wantFilter       string
+		wantRetries      int
+		wantReplyTweetID string
+	}{
+		{
+			name:             "file",
+			wantFilter:       "from-file",
+			wantRetries:      1,
+			wantReplyTweetID: "111",
+		},
+		{
+			name:             "env overrides file",
+			env:              map[string]string{"PROJECT_FILTER": "from-env", "RUN_RETRIES": "2"},
+			wantFilter:       "from-env",
+			wantRetries:      2,
+			wantReplyTweetID: "111",
+		},
+		{
+			name:             "flag overrides env and file",
+			env:              map[string]string{"PROJECT_FILTER": "from-env", "REPLY_TO_TWEET_ID": "222"},
+			flags:            map[string]string{"PROJECT_FILTER": "from-flag", "REPLY_TO_TWEET_ID": "333"},
+			wantFilter:       "from-flag",
+			wantRetries:      1,
+			wantReplyTweetID: "333",
+		},
+		{
+			name:             "flag not passed leaves env",
+			env:              map[string]string{"PROJECT_FILTER": "from-env"},
+			flags:            map[string]string{"PROJECT_FILTER": ""},
+			wantFilter:       "from-env",
+			wantRetries:      1,
+			wantReplyTweetID: "111",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			setEnvFromFlags(map[string]string{"CONFIG_FILE": configFile})
+			setEnvFromFlags(test.flags)
+
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ProjectFilter != test.wantFilter {
+				t.Errorf("got PROJECT_FILTER %q, want %q", cfg.ProjectFilter, test.wantFilter)
+			}
+			if cfg.RunRetries != test.wantRetries {
+				t.Errorf("got RUN_RETRIES %d, want %d", cfg.RunRetries, test.wantRetries)
+			}
+			if cfg.ReplyToTweetID != test.wantReplyTweetID {
+				t.Errorf("got REPLY_TO_TWEET_ID %q, want %q", cfg.ReplyToTweetID, test.wantReplyTweetID)
+			}
+		})
+	}
+}
+
+func TestConfigFileFlag(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "project_filter: from-env-file\n"))
+	setEnvFromFlags(map[string]string{"CONFIG_FILE": writeConfigFile(t, "project_filter: from-flag-file\n")})
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProjectFilter != "from-flag-file" {
+		t.Errorf("got PROJECT_FILTER %q, want the one from the -config file", cfg.ProjectFilter)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+)
+
+// How a todo that says work has started or finished begins, e.g. "Working on dark mode" and "Finished dark mode"
+var (
+	continuationStartPattern  = regexp.MustCompile(`(?i)^\s*(working on|started( on)?|starting( on)?|began|beginning)\s+`)
+	continuationFinishPattern = regexp.MustCompile(`(?i)^\s*(finished|done with|completed|wrapped up|shipped)\s+`)
+)
+
+// Merges todos that say work on something started into a later todo from the same project that says it finished, so
+// "Working on X" and "Finished X" make one tweet instead of two. The finishing todo is the one tweeted and the starting
+// one rides along in its MergedTodos, so it's marked as processed with it. This is a guess at what the todos mean, so
+// only todos that are at least threshold similar once the phrases are taken off are merged, a starting todo with
+// attachments is never merged since they'd be lost, and every pair considered is logged either way.
+func mergeContinuations(plannedTweets []plannedTweet, threshold int, logger *slog.Logger) ([]plannedTweet, []skippedTodo) {
+	mergedIDs := map[string]bool{}
+	skippedTodos := []skippedTodo{}
+	for i := range plannedTweets {
+		finish := &plannedTweets[i]
+		finishSubject, ok := continuationSubject(finish.Todo.Body, continuationFinishPattern)
+		if !ok {
+			continue
+		}
+		for _, start := range plannedTweets {
+			if mergedIDs[start.Todo.ID] || start.Todo.ID == finish.Todo.ID || start.Project.ID != finish.Project.ID || start.Todo.CreatedAt.After(finish.Todo.CreatedAt) {
+				continue
+			}
+			startSubject, ok := continuationSubject(start.Todo.Body, continuationStartPattern)
+			if !ok {
+				continue
+			}
+			similarity := lib_text.TokenSetRatio(startSubject, finishSubject)
+			decision := "merged"
+			switch {
+			case similarity < threshold:
+				decision = "not_similar_enough"
+			case len(start.Attachments) > 0 || start.TextImageBody != "" || len(start.CodeBlocks) > 0 || len(start.PollOptions) > 0:
+				decision = "start_has_media"
+			}
+			logger.Info("Considered merging continuation todos", "decision", decision, "similarity", similarity, "threshold", threshold, "start_todo_id", start.Todo.ID, "start_body", start.Todo.Body, "finish_todo_id", finish.Todo.ID, "finish_body", finish.Todo.Body)
+			if decision != "merged" {
+				continue
+			}
+			finish.MergedTodos = append(finish.MergedTodos, start.Todo)
+			mergedIDs[start.Todo.ID] = true
+			skippedTodos = append(skippedTodos, skippedTodo{ProjectID: start.Project.ID, TodoID: start.Todo.ID, Reason: "merged_continuation", MatchedBody: finish.Todo.Body})
+		}
+	}
+
+	remaining := []plannedTweet{}
+	for _, plannedTweet := range plannedTweets {
+		if !mergedIDs[plannedTweet.Todo.ID] {
+			remaining = append(remaining, plannedTweet)
+		}
+	}
+	return remaining, skippedTodos
+}
+
+// Returns what the todo says was started or finished, if it starts with one of the pattern's phrases
+func continuationSubject(body string, pattern *regexp.Regexp) (string, bool) {
+	location := pattern.FindStringIndex(body)
+	if location == nil {
+		return "", false
+	}
+	subject := strings.TrimSpace(body[location[1]:])
+	return subject, subject != ""
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	lib_discord "github.com/bakatz/wip-to-twitter-bridge/lib/discord"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// Discord has a much higher length limit than Twitter and can show images from URLs directly, so it gets the full body and the original attachments
+func buildDiscordMessage(todo lib_wip.Todo, hashtags []string, attachments []lib_wip.Attachment, hashtagPosition string) lib_discord.Message {
+	message := lib_discord.Message{
+		Content: truncateRunes(buildTweetMessage(todo.Body, hashtags, hashtagPosition), lib_discord.MAX_CONTENT_LENGTH),
+	}
+	for _, attachment := range attachments {
+		if len(message.Embeds) == lib_discord.MAX_EMBEDS {
+			break
+		}
+		message.Embeds = append(message.Embeds, lib_discord.Embed{Image: &lib_discord.EmbedImage{URL: attachment.URL}})
+	}
+	return message
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+)
+
+const DRY_RUN_DIFF_MESSAGE = "Dry run finished, nothing was tweeted"
+
+// What a run would do, reconciled against the state store: the todos that would be tweeted, the ones that were already
+// tweeted by an earlier run, and the ones filtered out for any other reason
+type dryRunDiff struct {
+	WouldTweet       []dryRunTodo  `json:"would_tweet"`
+	AlreadyProcessed []skippedTodo `json:"already_processed"`
+	Filtered         []skippedTodo `json:"filtered"`
+}
+
+type dryRunTodo struct {
+	ProjectID string `json:"project_id"`
+	TodoID    string `json:"todo_id"`
+	Text      string `json:"text"`
+}
+
+// Sorts the planned and skipped todos into a diff. Near duplicates are normally only found while tweeting, so they're
+// checked here too, including against the todos the dry run would have tweeted before them.
+func buildDryRunDiff(plannedTweets []plannedTweet, skippedTodos []skippedTodo, recentTweetBodies []string, cfg Config) dryRunDiff {
+	diff := dryRunDiff{WouldTweet: []dryRunTodo{}, AlreadyProcessed: []skippedTodo{}, Filtered: []skippedTodo{}}
+	for _, skipped := range skippedTodos {
+		if skipped.Reason == "already_tweeted" {
+			diff.AlreadyProcessed = append(diff.AlreadyProcessed, skipped)
+		} else {
+			diff.Filtered = append(diff.Filtered, skipped)
+		}
+	}
+
+	for _, plannedTweet := range plannedTweets {
+		if cfg.NearDuplicateThreshold > 0 && len(plannedTweet.PackedTodos) == 0 {
+			if matchedBody, ok := findNearDuplicate(plannedTweet.Todo.Body, recentTweetBodies, cfg.NearDuplicateThreshold); ok {
+				diff.Filtered = append(diff.Filtered, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "near_duplicate", MatchedBody: matchedBody})
+				continue
+			}
+		}
+		for _, todo := range plannedTweet.todos() {
+			diff.WouldTweet = append(diff.WouldTweet, dryRunTodo{ProjectID: plannedTweet.Project.ID, TodoID: todo.ID, Text: plannedTweet.Text})
+			recentTweetBodies = append(recentTweetBodies, todo.Body)
+		}
+	}
+	return diff
+}
+
+func logDryRunDiff(diff dryRunDiff, logger *slog.Logger) {
+	for _, todo := range diff.WouldTweet {
+		logger.Info("Dry run: would tweet", "project_id", todo.ProjectID, "todo_id", todo.TodoID, "message", todo.Text)
+	}
+	for _, skipped := range diff.AlreadyProcessed {
+		logger.Info("Dry run: already tweeted", "project_id", skipped.ProjectID, "todo_id", skipped.TodoID)
+	}
+	for _, skipped := range diff.Filtered {
+		logger.Info("Dry run: filtered", "project_id", skipped.ProjectID, "todo_id", skipped.TodoID, "reason", skipped.Reason)
+	}
+	logger.Info(DRY_RUN_DIFF_MESSAGE, "num_would_tweet", len(diff.WouldTweet), "num_already_processed", len(diff.AlreadyProcessed), "num_filtered", len(diff.Filtered))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type keywordHashtag struct {
+	pattern *regexp.Regexp
+	hashtag string
+}
+
+// Loads the keyword -> hashtag mapping, e.g. {"bug": "#bugfix", "launch": "#launch"}, from a JSON file if one is given
+func loadKeywordHashtags(mapping map[string]string, filePath string) ([]keywordHashtag, error) {
+	if filePath != "" {
+		fileBytes, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyword hashtags file: %w", err)
+		}
+		if err := json.Unmarshal(fileBytes, &mapping); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal keyword hashtags: %w", err)
+		}
+	}
+
+	// Sort the keywords so the hashtags always come out in the same order
+	keywords := make([]string, 0, len(mapping))
+	for keyword := range mapping {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	keywordHashtags := make([]keywordHashtag, 0, len(keywords))
+	for _, keyword := range keywords {
+		hashtag := "#" + strings.TrimPrefix(strings.TrimSpace(mapping[keyword]), "#")
+		if strings.TrimSpace(keyword) == "" || hashtag == "#" {
+			return nil, fmt.Errorf("keyword hashtags must not contain empty keywords or hashtags")
+		}
+		keywordHashtags = append(keywordHashtags, keywordHashtag{
+			pattern: regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(strings.TrimSpace(keyword)) + `\b`),
+			hashtag: hashtag,
+		})
+	}
+	return keywordHashtags, nil
+}
+
+// Picks the hashtags for a todo based on its body. If replaceDefault is set, the default hashtag is only used when no keywords match.
+func selectHashtags(body string, keywordHashtags []keywordHashtag, replaceDefault bool) []string {
+	matched := []string{}
+	for _, kh := range keywordHashtags {
+		if kh.pattern.MatchString(body) {
+			matched = append(matched, kh.hashtag)
+		}
+	}
+
+	hashtags := []string{DEFAULT_HASHTAG}
+	if replaceDefault && len(matched) > 0 {
+		hashtags = []string{}
+	}
+	return dedupeHashtags(append(hashtags, matched...))
+}
+
+func dedupeHashtags(hashtags []string) []string {
+	seen := map[string]bool{}
+	deduped := []string{}
+	for _, hashtag := range hashtags {
+		key := strings.ToLower(hashtag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, hashtag)
+	}
+	return deduped
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// tweetConfirmer asks on the terminal before each tweet goes out, for careful manual runs. Answering e lets the text be
+// edited first. Once the input runs out nothing else is tweeted, as if the rest of the run were a dry run.
+type tweetConfirmer struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+func newTweetConfirmer(in io.Reader, out io.Writer) *tweetConfirmer {
+	return &tweetConfirmer{in: bufio.NewReader(in), out: out}
+}
+
+// Interactive runs ask before every tweet, which needs someone at a terminal to answer. Without one the run is turned
+// into a dry run and there's no confirmer.
+func newConfiguredConfirmer(cfg *Config, logger *slog.Logger) *tweetConfirmer {
+	if !cfg.Interactive {
+		return nil
+	}
+	if !isInteractiveTerminal(os.Stdin) {
+		logger.Warn("INTERACTIVE needs a terminal to answer on, doing a dry run instead")
+		cfg.DryRunDiff = true
+		return nil
+	}
+	return newTweetConfirmer(os.Stdin, os.Stderr)
+}
+
+// Piped or redirected input can't answer prompts, in which case the run should be a dry run instead
+func isInteractiveTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns the text to tweet, which may have been edited, and whether to tweet it at all
+func (c *tweetConfirmer) confirm(plannedTweet plannedTweet) (string, bool) {
+	text := plannedTweet.Text
+	for !c.eof {
+		fmt.Fprintf(c.out, "\nProject %s, todo %s:\n%s\n", plannedTweet.Project.Name, plannedTweet.Todo.ID, text)
+		switch strings.ToLower(c.readLine("Tweet this? [y]es/[n]o/[e]dit: ")) {
+		case "y", "yes":
+			return text, true
+		case "n", "no":
+			return "", false
+		case "e", "edit":
+			edited := strings.ReplaceAll(c.readLine(`New text on one line, with \n for line breaks: `), `\n`, "\n")
+			if strings.TrimSpace(edited) == "" {
+				fmt.Fprintln(c.out, "Keeping the text as it was")
+			} else if length := tweetLength(edited); length > MAX_TWEET_LENGTH {
+				fmt.Fprintf(c.out, "That's %d characters, which is over the limit of %d, keeping the text as it was\n", length, MAX_TWEET_LENGTH)
+			} else {
+				text = edited
+			}
+		}
+	}
+	return "", false
+}
+
+func (c *tweetConfirmer) readLine(prompt string) string {
+	fmt.Fprint(c.out, prompt)
+	line, err := c.in.ReadString('\n')
+	if err != nil {
+		c.eof = true
+		fmt.Fprintln(c.out, "\nNo more input, not tweeting anything else")
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+const (
+	LOW_CONTENT_POLICY_TWEET   = "tweet"
+	LOW_CONTENT_POLICY_SKIP    = "skip"
+	LOW_CONTENT_POLICY_AUGMENT = "augment"
+)
+
+// Returns emoji_only or link_only for a todo whose body says nothing on its own, or an empty string for one that does. An
+// attachment says enough to go with even a lone 🎉, so todos with one are never low content.
+func lowContentKind(todo lib_wip.Todo) string {
+	if len(todo.Attachments) > 0 {
+		return ""
+	}
+	if lib_text.IsEmojiOnly(todo.Body) {
+		return "emoji_only"
+	}
+	if lib_text.IsLinkOnly(todo.Body) {
+		return "link_only"
+	}
+	return ""
+}
+
+// Puts the project's name in front of the body, so a tweet that's only a link or emoji says what it's about
+func augmentLowContentBody(project lib_wip.Project, body string) string {
+	return project.Name + ": " + body
+}
@@ -2,20 +2,23 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"io"
+	"errors"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	twitter11 "github.com/ChimeraCoder/anaconda"
 	"github.com/aws/aws-lambda-go/lambda"
+	lib_discord "github.com/bakatz/wip-to-twitter-bridge/lib/discord"
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_translate "github.com/bakatz/wip-to-twitter-bridge/lib/translate"
+	lib_webhook "github.com/bakatz/wip-to-twitter-bridge/lib/webhook"
 	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
-	"github.com/dghubble/oauth1"
-	twitter2 "github.com/g8rswimmer/go-twitter/v2"
 	"github.com/joho/godotenv"
 )
 
@@ -23,14 +26,46 @@ type Response struct {
 	Message         string `json:"message"`
 	Code            string `json:"code,omitempty"`
 	NumTodosTweeted int    `json:"num_todos_tweeted"`
+	NumDiscordPosts int    `json:"num_discord_posts"`
+	// Only set when translations are enabled
+	NumTranslationsTweeted int `json:"num_translations_tweeted,omitempty"`
+	// Todos completed during a quiet period, which will be tweeted by the first run after it, todos queued for the next of
+	// the POSTING_TIMES and todos requeued because their attachments were still processing
+	NumTodosDeferred int `json:"num_todos_deferred,omitempty"`
+	// Only set on the run that sends the end of day stats tweet
+	DailyStatsTweeted bool `json:"daily_stats_tweeted,omitempty"`
+	// Only set on the run that sends the weekly recap thread
+	WeeklyRecapTweeted bool          `json:"weekly_recap_tweeted,omitempty"`
+	SkippedTodos       []skippedTodo `json:"skipped_todos,omitempty"`
+	// Totals for the attachments uploaded across all of the run's tweets
+	NumAttachmentsUploaded  int `json:"num_attachments_uploaded,omitempty"`
+	AttachmentBytesUploaded int `json:"attachment_bytes_uploaded,omitempty"`
+	// Attachments whose contents had already been uploaded this run, with DEDUP_ATTACHMENTS_BY_HASH
+	NumAttachmentsDeduplicated int `json:"num_attachments_deduplicated,omitempty"`
+	// individual or burst_thread, when BURST_THREAD_THRESHOLD is set
+	TweetMode string `json:"tweet_mode,omitempty"`
+	// How many times the run was attempted, when it had to be retried
+	Attempts int `json:"attempts,omitempty"`
+	// Only set by DRY_RUN_DIFF runs
+	DryRunDiff *dryRunDiff `json:"dry_run_diff,omitempty"`
 }
 
 const (
 	PRIVATE_ENTITY_IDENTIFIER     = "!private"
+	TWEET_MODE_INDIVIDUAL         = "individual"
+	TWEET_MODE_BURST_THREAD       = "burst_thread"
 	LOOKBACK_WINDOW_MINUTES       = 60
 	SUCCESS_MESSAGE               = "Function finished without errors"
+	INTERRUPTED_MESSAGE           = "Function was interrupted before all todos were tweeted"
 	CONNECTION_TIMEOUT_DURATION   = 5 * time.Second
 	CONTENT_TYPE_APPLICATION_JSON = "application/json"
+	// Twitter allows at most 4 images per tweet
+	MAX_MEDIA_PER_TWEET                         = 4
+	DEFAULT_MAX_ATTACHMENTS_PER_TODO            = MAX_MEDIA_PER_TWEET
+	DEFAULT_WIP_CACHE_TTL_MINUTES               = 10
+	DEFAULT_DAILY_STATS_HOUR                    = 23
+	DEFAULT_ATTACHMENT_DOWNLOAD_TIMEOUT_SECONDS = 30
+	DEFAULT_ATTACHMENT_DOWNLOAD_RETRIES         = 2
 )
 
 // Dummy auth struct just to satisfy the API
@@ -44,123 +79,661 @@ func makeAndLogErrorResponse(message string, code string, logger *slog.Logger) R
 	return response
 }
 
-func Handler(ctx context.Context) (Response, error) {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+// postError is returned when posting a todo fails, and carries what to report in the error response
+type postError struct {
+	message string
+	code    string
+	err     error
+}
+
+func (e *postError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *postError) Unwrap() error {
+	return e.err
+}
+
+func makeAndLogPostErrorResponse(err error, logger *slog.Logger) (Response, error) {
+	var postErr *postError
+	if !errors.As(err, &postErr) {
+		postErr = &postError{message: "Error posting a todo", code: "post_todo_error", err: err}
+	}
+	return makeAndLogErrorResponse(postErr.message, postErr.code, logger), postErr.err
+}
+
+// Posts each of the planned tweet's todos to Discord, returning how many were posted. Discord has no length limit to
+// pack todos for, so it still gets one message per todo.
+func postToDiscord(discordClient *lib_discord.Client, plannedTweet plannedTweet, cfg Config, logger *slog.Logger) (int, error) {
+	numPosts := 0
+	for i, todo := range plannedTweet.todos() {
+		attachments := todo.Attachments
+		if i == 0 {
+			attachments = plannedTweet.Attachments
+		}
+		if err := discordClient.PostMessage(buildDiscordMessage(todo, plannedTweet.Hashtags, attachments, cfg.HashtagPosition)); err != nil {
+			return numPosts, err
+		}
+		logger.Info("Discord message sent successfully")
+		numPosts++
+	}
+	return numPosts, nil
+}
 
-	// Get all the secrets we need
-	wipAPIKey := os.Getenv("WIP_API_KEY")
-	twitterAPIKey := os.Getenv("TWITTER_API_KEY")
-	twitterAPIKeySecret := os.Getenv("TWITTER_API_KEY_SECRET")
-	twitterAccessToken := os.Getenv("TWITTER_ACCESS_TOKEN")
-	twitterAccessTokenSecret := os.Getenv("TWITTER_ACCESS_TOKEN_SECRET")
-	if wipAPIKey == "" || twitterAPIKey == "" || twitterAPIKeySecret == "" || twitterAccessToken == "" || twitterAccessTokenSecret == "" {
+// Logs JSON to stdout at the level set by LOG_LEVEL (debug, info, warn or error), defaulting to info
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+func Handler(ctx context.Context) (response Response, err error) {
+	logger := newLogger()
+	defer flushTracing()
+	ctx, span := tracer.Start(ctx, "handler")
+	defer span.End()
+	startedAt := time.Now().UTC()
+	currentRunID := runID(ctx)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return makeAndLogErrorResponse(err.Error(), "invalid_config", logger), nil
+	}
+
+	// Record how the run went, however it ends
+	var tweetedTodos []tweetedTodo
+	if cfg.RunManifestS3Prefix != "" {
+		defer func() {
+			manifest := runManifest{RunID: currentRunID, StartedAt: startedAt, FinishedAt: time.Now().UTC(), Response: response, TweetedTodos: tweetedTodos}
+			if err != nil {
+				manifest.Error = err.Error()
+			}
+			writeRunManifest(context.WithoutCancel(ctx), cfg.RunManifestS3Prefix, manifest, logger)
+		}()
+	}
+
+	// Make sure we have all the secrets we need. Twitter is optional as long as todos have somewhere else to go, but if
+	// any of its credentials are set they all have to be.
+	if cfg.WIPAPIKey == "" || (cfg.twitterEnabled() && cfg.Twitter.validate() != nil) {
 		return makeAndLogErrorResponse("Cannot start the function because some of the required evars are missing, set them and run the function again", "missing_evars", logger), nil
 	}
+	if !cfg.twitterEnabled() && cfg.DiscordWebhookURL == "" {
+		return makeAndLogErrorResponse("Cannot start the function because there's nowhere to post todos, set the TWITTER_* credentials or DISCORD_WEBHOOK_URL and run the function again", "no_output_platform", logger), nil
+	}
+	if cfg.twitterEnabled() {
+		if err := checkTwitterCredentialFormats(cfg); err != nil {
+			return makeAndLogErrorResponse(err.Error(), "invalid_twitter_credentials", logger), nil
+		}
+	} else {
+		logger.Info("No Twitter credentials are set, only posting to Discord")
+	}
+
+	confirmer := newConfiguredConfirmer(&cfg, logger)
+
+	stateStore, err := newStateStore(ctx, cfg)
+	if err != nil {
+		return makeAndLogErrorResponse("Could not set up the state store", "state_store_error", logger), err
+	}
+
+	// Make sure a slow run and the next scheduled one can't both tweet the same todos. Per account locks only keep runs
+	// tweeting from the same account apart.
+	var busyAccounts map[string]bool
+	if cfg.RunLock {
+		// validate makes sure RunLock is only set with the DynamoDB backend, which is a Locker
+		locker := stateStore.(lib_state.Locker)
+		lockOwner := currentRunID
+		var acquiredLockNames []string
+		if cfg.RunLockPerAccount && cfg.twitterEnabled() {
+			acquiredLockNames, busyAccounts, err = acquireAccountLocks(ctx, locker, cfg, lockOwner)
+		} else {
+			var acquired bool
+			acquired, err = locker.AcquireLock(ctx, cfg.RunLockName, lockOwner, time.Duration(cfg.RunLockTTLSeconds)*time.Second)
+			if acquired {
+				acquiredLockNames = []string{cfg.RunLockName}
+			}
+		}
+		defer func() {
+			for _, lockName := range acquiredLockNames {
+				if err := locker.ReleaseLock(context.WithoutCancel(ctx), lockName, lockOwner); err != nil {
+					logger.Error("Could not release the run lock, it will expire on its own", "lock_name", lockName, "error", err)
+				}
+			}
+		}()
+		if err != nil {
+			return makeAndLogErrorResponse("Could not acquire the run lock", "state_store_error", logger), err
+		}
+		if len(acquiredLockNames) == 0 {
+			return makeAndLogErrorResponse("Another run is still in progress, skipping this one", "run_in_progress", logger), nil
+		}
+		if len(busyAccounts) > 0 {
+			logger.Info("Another run is busy with some of the accounts, skipping their todos", "num_busy_accounts", len(busyAccounts))
+		}
+	}
+
+	// A dry run reads the state store like a normal run would, but leaves it exactly as it was
+	if cfg.DryRunDiff {
+		stateStore = lib_state.NewReadOnlyStore(stateStore)
+	}
+
+	retries := newRetryBudget(cfg.RunRetryBudget)
+
+	// Counted once per invocation rather than per attempt, so retries don't throw the schedule off
+	postingRun := true
+	if cfg.TweetEveryNRuns > 1 {
+		postingRun, err = countPostingRun(ctx, stateStore, cfg.TweetEveryNRuns)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not count the run in the state store", "state_store_error", logger), err
+		}
+	}
 
+	// Cold starts occasionally fail to reach WIP or Twitter at all, and both have the odd moment of answering with an
+	// error that goes away on its own. Todos that did get tweeted are in the state store, so running again only picks up
+	// where the failed attempt stopped.
+	for attempt := 1; ; attempt++ {
+		response, err = runPipeline(ctx, cfg, stateStore, postingRun, busyAccounts, retries, confirmer, &tweetedTodos, logger)
+		retryable := err != nil && attempt <= cfg.RunRetries && ctx.Err() == nil && cfg.isRetryable(err)
+		if retryable && !retries.take() {
+			logger.Warn("Run failed with a retryable error, but the retry budget is used up", "attempt", attempt, "run_retry_budget", cfg.RunRetryBudget, "error", err)
+			retryable = false
+		}
+		if !retryable {
+			if attempt > 1 {
+				response.Attempts = attempt
+			}
+			logTodoStatuses(tweetedTodos, response.SkippedTodos, cfg, logger)
+			return response, err
+		}
+		logger.Warn("Run failed with a retryable error, retrying", "attempt", attempt, "max_retries", cfg.RunRetries, "error", err)
+		time.Sleep(time.Duration(attempt) * RUN_RETRY_BACKOFF)
+	}
+}
+
+// Fetches the todos and tweets the ones that are due. This is the part of a run that's retried after connection errors,
+// the config, state store and run lock are only set up once per invocation. Runs that aren't posting runs defer their
+// todos to the next one that is. With a confirmer, each tweet only goes out once it's been confirmed. Todos for the busy
+// accounts are left for a later run. Attachment download retries come out of the run's retry budget.
+func runPipeline(ctx context.Context, cfg Config, stateStore lib_state.Store, postingRun bool, busyAccounts map[string]bool, retries *retryBudget, confirmer *tweetConfirmer, tweetedTodos *[]tweetedTodo, logger *slog.Logger) (Response, error) {
 	// Get all of the completed todos from wip.co
-	wipClient := lib_wip.NewClient(wipAPIKey)
+	wipClient, err := newWIPClient(cfg.WIPAPIKey, cfg, logger)
+	if err != nil {
+		return makeAndLogErrorResponse("Could not load the WIP cache file", "wip_cache_error", logger), err
+	}
 
-	projectsLimit := 100
-	projects, err := wipClient.GetMyProjects(&projectsLimit, nil)
+	_, fetchSpan := tracer.Start(ctx, "fetch_wip_todos")
+	publicTodos, err := fetchPublicTodos(wipClient)
+	fetchSpan.End()
+	if errors.Is(err, lib_wip.ErrUnauthorized) {
+		return makeAndLogErrorResponse("WIP rejected the API key, check WIP_API_KEY and that WIP_AUTH_SCHEME matches the kind of key it is", "wip_auth_error", logger), err
+	}
+	if errors.Is(err, lib_wip.ErrUnexpectedSchema) {
+		return makeAndLogErrorResponse("WIP returned a response in an unexpected format, it may have changed its API", "wip_unexpected_schema", logger), err
+	}
 	if err != nil {
-		return makeAndLogErrorResponse("Could not call GetMyProjects", "wip_api_error", logger), nil
+		return makeAndLogErrorResponse("Error getting completed todos from WIP", "wip_api_error", logger), err
+	}
+	// For backfills and testing, a run can be limited to a single project
+	if cfg.ProjectFilter != "" {
+		publicTodos, err = filterProjects(publicTodos, cfg.ProjectFilter)
+		if err != nil {
+			return makeAndLogErrorResponse(err.Error(), "project_not_found", logger), nil
+		}
 	}
+	now := time.Now().UTC()
+	plannedTweets, skippedTodos := planTweets(publicTodos, cfg, newLookbackWindow(now, cfg.WindowGraceSeconds, cfg.MinAgeBeforeTweetMinutes), logger)
 
-	twitter11Client, twitter2Client := setupTwitterClients(twitterAPIKey, twitterAPIKeySecret, twitterAccessToken, twitterAccessTokenSecret)
+	twitterRouter := newTwitterRouter(cfg.Twitter, cfg.ProjectTwitterCredentials, cfg.TwitterUserAgent)
 
-	startOfLookbackWindow := time.Now().UTC().Add(-LOOKBACK_WINDOW_MINUTES * time.Minute)
-	numTodosTweeted := 0
-	// Send out a tweet for each of the completed todos
-	for _, project := range projects.Data {
-		// Skip replicating all todos in projects marked as "private"
-		if strings.Contains(project.Pitch, PRIVATE_ENTITY_IDENTIFIER) {
+	// Skipped future todos are recorded, otherwise the run whose window they fall in would tweet them after all
+	for _, skipped := range skippedTodos {
+		if skipped.Reason != "future_completion" {
 			continue
 		}
+		if err := stateStore.MarkProcessed(ctx, processedTweetID(twitterRouter.accountFor(skipped.ProjectID), skipped.TodoID)); err != nil {
+			return makeAndLogErrorResponse("Could not record the skipped todo in the state store", "state_store_error", logger), err
+		}
+	}
+
+	// Mirroring todos to Discord is only enabled when a webhook is configured
+	var discordClient *lib_discord.Client
+	if cfg.DiscordWebhookURL != "" {
+		discordClient = lib_discord.NewClient(cfg.DiscordWebhookURL)
+		discordClient.SetSigningSecret(cfg.WebhookSigningSecret)
+	}
+
+	// As is the outbound webhook for automation tools like Zapier
+	var outboundWebhookClient *lib_webhook.Client
+	if cfg.OutboundWebhookURL != "" {
+		outboundWebhookClient = lib_webhook.NewClient(cfg.OutboundWebhookURL)
+		outboundWebhookClient.SetSigningSecret(cfg.WebhookSigningSecret)
+	}
+
+	// Likewise, translations are only tweeted when a translation API is configured
+	var translator *lib_translate.Client
+	if cfg.TranslateAPIURL != "" && cfg.twitterEnabled() {
+		translator = lib_translate.NewClient(cfg.TranslateAPIURL, cfg.TranslateAPIKey)
+	}
+
+	// Draining runs only post the queued todos that are due, new ones are left for the runs that queue them
+	if cfg.DrainQueue {
+		plannedTweets = nil
+	}
+
+	// On the very first run, only remember what's already completed so a wide lookback window can't flood the timeline
+	if cfg.FirstRunSuppress {
+		firstRun, err := isFirstRun(ctx, stateStore)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
+		}
+		if firstRun {
+			baselineSkippedTodos, err := establishBaseline(ctx, stateStore, plannedTweets, twitterRouter, now)
+			if err != nil {
+				return makeAndLogErrorResponse("Could not record the baseline in the state store", "state_store_error", logger), err
+			}
+			logger.Info("First run, marked todos as processed without tweeting them", "num_todos", len(baselineSkippedTodos))
+			skippedTodos = append(skippedTodos, baselineSkippedTodos...)
+			plannedTweets = nil
+		}
+	}
+
+	// During quiet periods, and on runs between posting runs, todos wait in the state store and the first run after
+	// catches up on them. With POSTING_TIMES they wait for the next posting time instead.
+	numTodosDeferred := 0
+	var deferredTodos []deferredTodo
+	var deferredSource []projectWithTodos
+	scheduledFor := time.Time{}
+	if len(cfg.postingTimes) > 0 {
+		scheduledFor = nextPostingTime(cfg.postingTimes, now, cfg.location)
+	}
+	if quietTime := isQuietTime(cfg, now); quietTime || !postingRun {
+		numTodosDeferred, err = deferPlannedTweets(ctx, stateStore, plannedTweets, scheduledFor)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not defer todos in the state store", "state_store_error", logger), err
+		}
+		if quietTime {
+			logger.Info("Quiet period, deferring todos to the next active run", "num_todos_deferred", numTodosDeferred)
+		} else {
+			logger.Info("Not a posting run, deferring todos to the next one", "num_todos_deferred", numTodosDeferred, "tweet_every_n_runs", cfg.TweetEveryNRuns)
+		}
+		plannedTweets = nil
+	} else if cfg.defersTodos() {
+		if !scheduledFor.IsZero() && !cfg.DrainQueue {
+			numTodosDeferred, err = deferPlannedTweets(ctx, stateStore, plannedTweets, scheduledFor)
+			if err != nil {
+				return makeAndLogErrorResponse("Could not queue todos in the state store", "state_store_error", logger), err
+			}
+			logger.Info("Queued todos for the next posting time", "num_todos_queued", numTodosDeferred, "scheduled_for", scheduledFor)
+			plannedTweets = nil
+		}
+		allDeferredTodos, err := loadDeferredTodos(ctx, stateStore)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
+		}
+		// Todos requeued until later wait for then, even if they're also in this run's window
+		var waitingTodoIDs map[string]bool
+		deferredTodos, waitingTodoIDs = splitDueDeferredTodos(allDeferredTodos, now)
+		deferredSource, err = fetchOlderDeferredTodos(wipClient, publicTodos, deferredTodos)
+		if err != nil {
+			return makeAndLogErrorResponse("Error getting completed todos from WIP", "wip_api_error", logger), err
+		}
+		deferredPlannedTweets, deferredSkippedTodos := planTweets(findDeferredTodos(deferredSource, deferredTodos), cfg, allTime(), logger)
+		skippedTodos = append(skippedTodos, deferredSkippedTodos...)
+		skippedTodos = append(skippedTodos, goneDeferredTodos(deferredSource, deferredTodos, logger)...)
+		// With a grace period a deferred todo can also be in this run's window, so don't plan it twice
+		plannedIDs := map[string]bool{}
+		windowTweets := []plannedTweet{}
+		for _, plannedTweet := range plannedTweets {
+			if !waitingTodoIDs[plannedTweet.Todo.ID] {
+				plannedIDs[plannedTweet.Todo.ID] = true
+				windowTweets = append(windowTweets, plannedTweet)
+			}
+		}
+		catchUpTweets := []plannedTweet{}
+		for _, plannedTweet := range deferredPlannedTweets {
+			if !plannedIDs[plannedTweet.Todo.ID] {
+				catchUpTweets = append(catchUpTweets, plannedTweet)
+			}
+		}
+		plannedTweets = append(catchUpTweets, windowTweets...)
+	}
+
+	attachmentDownloader := lib_media.NewDownloader(time.Duration(cfg.AttachmentDownloadTimeoutSeconds)*time.Second, cfg.AttachmentDownloadRetries)
+	attachmentDownloader.SetRetryAllowed(retries.take)
+	mediaIDCache := newMediaCache(stateStore)
+
+	// Overlapping windows or reruns can come across todos we've already tweeted. These are dropped before packing so
+	// a pack only ever holds todos that still need tweeting.
+	plannedTweets, alreadyTweetedTodos, err := dropAlreadyTweeted(ctx, stateStore, twitterRouter, plannedTweets, logger)
+	if err != nil {
+		return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
+	}
+	skippedTodos = append(skippedTodos, alreadyTweetedTodos...)
+	// With an approval queue only todos someone has approved go out, the rest wait in the state store
+	if cfg.ApprovalQueue {
+		var approvalSkippedTodos []skippedTodo
+		plannedTweets, approvalSkippedTodos, err = applyApprovalQueue(ctx, stateStore, publicTodos, plannedTweets, twitterRouter, cfg, now, logger)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not update the approval queue in the state store", "state_store_error", logger), err
+		}
+		skippedTodos = append(skippedTodos, approvalSkippedTodos...)
+	}
+	// Another run is tweeting from the busy accounts, including any deferred or approved todos of theirs, so those stay
+	// where they are for it
+	var busyAccountTodos []skippedTodo
+	plannedTweets, busyAccountTodos = dropBusyAccounts(plannedTweets, twitterRouter, busyAccounts, logger)
+	skippedTodos = append(skippedTodos, busyAccountTodos...)
+	if cfg.ContinuationMergeThreshold > 0 {
+		var mergedTodos []skippedTodo
+		plannedTweets, mergedTodos = mergeContinuations(plannedTweets, cfg.ContinuationMergeThreshold, logger)
+		skippedTodos = append(skippedTodos, mergedTodos...)
+	}
+	if cfg.PackTodos {
+		plannedTweets = packPlannedTweets(plannedTweets, cfg.HashtagPosition)
+	}
 
-		todos, err := wipClient.GetProjectTodos(project.ID, nil, nil)
+	// Near duplicates are checked against the bodies of recent tweets, including earlier ones from this run
+	var recentTweetBodies []string
+	if cfg.NearDuplicateThreshold > 0 {
+		recentTweetBodies, err = loadRecentTweetBodies(ctx, stateStore)
 		if err != nil {
-			return makeAndLogErrorResponse("Error getting project todos", "wip_api_error", logger), err
+			return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
 		}
+	}
 
-		for _, todo := range todos.Data {
-			// If this todo was completed more than an hour ago, don't bother tweeting about it because we've already covered it in a previous run (we run every hour to catch todos from the previous hour)
-			// Also skip private todos that should not be replicated to twitter.
-			if todo.CreatedAt.Before(startOfLookbackWindow) || strings.Contains(todo.Body, PRIVATE_ENTITY_IDENTIFIER) {
+	if cfg.DryRunDiff {
+		diff := buildDryRunDiff(plannedTweets, skippedTodos, recentTweetBodies, cfg)
+		logDryRunDiff(diff, logger)
+		return Response{Message: DRY_RUN_DIFF_MESSAGE, NumTodosDeferred: numTodosDeferred, DryRunDiff: &diff}, nil
+	}
+
+	// Tweets posted by hand count too, so the account's own timeline is another source of duplicates
+	var timeline *timelineCache
+	if cfg.TimelineDedupSize > 0 && cfg.twitterEnabled() {
+		timeline = newTimelineCache(cfg.TimelineDedupSize, logger)
+	}
+
+	// A burst of todos goes out as one thread per account rather than flooding the timeline
+	tweetMode := ""
+	burstThreadTweetIDs := map[string]string{}
+	replyThreadTweetIDs := map[string]string{}
+	if cfg.BurstThreadThreshold > 0 && cfg.twitterEnabled() {
+		tweetMode = TWEET_MODE_INDIVIDUAL
+		if len(plannedTweets) > cfg.BurstThreadThreshold {
+			tweetMode = TWEET_MODE_BURST_THREAD
+			logger.Info("Burst of todos, tweeting them as a thread", "num_tweets", len(plannedTweets), "burst_thread_threshold", cfg.BurstThreadThreshold)
+		}
+	}
+
+	numTodosTweeted := 0
+	uploadStats := attachmentStats{}
+	numDiscordPosts := 0
+	numTranslationsTweeted := 0
+	// Send out a tweet for each of the completed todos
+	for _, plannedTweet := range plannedTweets {
+		// Don't start any new tweets once we've been asked to shut down
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Projects can be routed to their own account, otherwise they're tweeted from the default one
+		twitterAccount := twitterRouter.accountFor(plannedTweet.Project.ID)
+
+		// Rephrasing the same update shouldn't get it tweeted twice either. Packs are left alone rather than dropping
+		// every todo in them because of one.
+		if cfg.NearDuplicateThreshold > 0 && len(plannedTweet.PackedTodos) == 0 {
+			if matchedBody, ok := findNearDuplicate(plannedTweet.Todo.Body, recentTweetBodies, cfg.NearDuplicateThreshold); ok {
+				logger.Info("Skipping todo", "todo_id", plannedTweet.Todo.ID, "reason", "near_duplicate", "matched_body", matchedBody)
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "near_duplicate", MatchedBody: matchedBody})
 				continue
 			}
-			tweetMessage := "✅ " + todo.Body + " #buildinpublic"
-			mediaIDs := []string{}
+		}
 
-			for _, attachment := range todo.Attachments {
-				mediaID, err := uploadAttachmentFromTodo(attachment, twitter11Client)
-				if err != nil {
-					return makeAndLogErrorResponse("Error uploading attachment", "upload_attachment_error", logger), err
-				}
-				mediaIDs = append(mediaIDs, mediaID)
+		if timeline != nil && len(plannedTweet.PackedTodos) == 0 {
+			if matchedText, ok := findOnTimeline(plannedTweet.Todo.Body, timeline.textsFor(ctx, twitterAccount), cfg.NearDuplicateThreshold); ok {
+				logger.Info("Skipping todo", "todo_id", plannedTweet.Todo.ID, "reason", "already_on_timeline", "matched_body", matchedText)
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "already_on_timeline", MatchedBody: matchedText})
+				continue
+			}
+		}
+
+		// Continue the project's thread from where the last run left off. Its first todo starts a new thread.
+		threadKey := projectThreadKey(twitterAccount, plannedTweet.Project.ID)
+		projectThreads := cfg.ProjectThreads && cfg.twitterEnabled()
+		if projectThreads {
+			plannedTweet.InReplyToTweetID, err = stateStore.GetValue(ctx, threadKey)
+			if err != nil {
+				return makeAndLogErrorResponse("Could not read from the state store", "state_store_error", logger), err
+			}
+		}
+
+		if tweetMode == TWEET_MODE_BURST_THREAD {
+			plannedTweet.InReplyToTweetID = burstThreadTweetIDs[twitterAccount.UserID]
+		}
+
+		// Answering someone's tweet, the first todo replies to it and the rest thread under the first. If the tweet was
+		// deleted or can't be replied to, tweetTodo starts a new thread and the rest follow that one instead.
+		if cfg.ReplyToTweetID != "" {
+			plannedTweet.InReplyToTweetID = cfg.ReplyToTweetID
+			if previousTweetID, ok := replyThreadTweetIDs[twitterAccount.UserID]; ok {
+				plannedTweet.InReplyToTweetID = previousTweetID
 			}
+		}
+
+		// Replies can leave the hashtags to the tweet that started the thread. Discord isn't threaded, so it keeps them.
+		tweetHashtags := plannedTweet.Hashtags
+		if cfg.HashtagOnThreadRootOnly && plannedTweet.InReplyToTweetID != "" && !cfg.RawBody {
+			tweetHashtags = nil
+			plannedTweet.Text = fitTweetMessageWithin("", plannedTweet.Body, tweetHashtags, cfg.HashtagPosition, plannedTweet.maxTextLength())
+		}
 
-			logger.Info("About to tweet this message", "message", tweetMessage)
+		// The intro only goes on the run's first tweet, and only once we know that todo is actually going out
+		if cfg.FirstTweetPrefix != "" && numTodosTweeted == 0 {
+			plannedTweet.Text = fitTweetMessageWithin(cfg.FirstTweetPrefix, plannedTweet.Body, tweetHashtags, cfg.HashtagPosition, plannedTweet.maxTextLength())
+		}
 
-			createTweetRequest := &twitter2.CreateTweetRequest{
-				Text: tweetMessage,
+		if confirmer != nil {
+			text, ok := confirmer.confirm(plannedTweet)
+			if !ok {
+				logger.Info("Skipping todo", "todo_id", plannedTweet.Todo.ID, "reason", "declined")
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "declined"})
+				continue
 			}
+			plannedTweet.Text = text
+		}
 
-			if len(mediaIDs) > 0 {
-				createTweetRequest.Media = &twitter2.CreateTweetMedia{
-					IDs: mediaIDs,
+		// Without Twitter, Discord takes the tweet's place, so like the tweet it has to go out before the todo is recorded
+		tweetID := ""
+		if cfg.twitterEnabled() {
+			tweetID, err = tweetTodo(ctx, plannedTweet, twitterAccount, attachmentDownloader, mediaIDCache, cfg, &uploadStats, logger)
+			// Rather than failing the run, the todo waits for its attachment to finish processing
+			if errors.Is(err, lib_media.ErrNotReady) && cfg.RequeueProcessingAttachments {
+				deferUntil := time.Now().UTC().Add(time.Duration(cfg.RequeueDelayMinutes) * time.Minute)
+				if err := requeuePlannedTweet(context.WithoutCancel(ctx), stateStore, plannedTweet, deferUntil); err != nil {
+					return makeAndLogErrorResponse("Could not defer todos in the state store", "state_store_error", logger), err
+				}
+				for _, todo := range plannedTweet.todos() {
+					logger.Info("Skipping todo", "todo_id", todo.ID, "reason", "attachment_not_ready", "defer_until", deferUntil)
+					skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: todo.ID, Reason: "attachment_not_ready"})
+					numTodosDeferred++
+				}
+				continue
+			}
+			if err != nil {
+				logTodoFailed(plannedTweet, err, cfg, logger)
+				return makeAndLogPostErrorResponse(err, logger)
+			}
+		} else {
+			numPosts, err := postToDiscord(discordClient, plannedTweet, cfg, logger)
+			numDiscordPosts += numPosts
+			if err != nil {
+				logTodoFailed(plannedTweet, err, cfg, logger)
+				return makeAndLogErrorResponse("Error posting to Discord", "discord_post_error", logger), err
+			}
+		}
+		for _, todo := range plannedTweet.todos() {
+			if err := stateStore.MarkProcessed(context.WithoutCancel(ctx), processedTweetID(twitterAccount, todo.ID)); err != nil {
+				return makeAndLogErrorResponse("Could not record the tweeted todo in the state store", "state_store_error", logger), err
+			}
+			if cfg.NearDuplicateThreshold > 0 {
+				recentTweetBodies, err = saveRecentTweetBody(context.WithoutCancel(ctx), stateStore, todo.Body, cfg.NearDuplicateHistorySize)
+				if err != nil {
+					return makeAndLogErrorResponse("Could not record the tweeted todo in the state store", "state_store_error", logger), err
 				}
 			}
-			_, err := twitter2Client.CreateTweet(context.Background(), *createTweetRequest)
+			if cfg.twitterEnabled() {
+				numTodosTweeted++
+				*tweetedTodos = append(*tweetedTodos, tweetedTodo{ProjectID: plannedTweet.Project.ID, TodoID: todo.ID, TweetID: tweetID, TweetedAt: time.Now().UTC()})
+			}
+			if cfg.WeeklyRecap && cfg.twitterEnabled() {
+				if err := recordRecapTodo(context.WithoutCancel(ctx), stateStore, plannedTweet.Project, todo, time.Now().UTC()); err != nil {
+					return makeAndLogErrorResponse("Could not record the tweeted todo in the state store", "state_store_error", logger), err
+				}
+			}
+		}
+		for _, todo := range plannedTweet.MergedTodos {
+			if err := stateStore.MarkProcessed(context.WithoutCancel(ctx), processedTweetID(twitterAccount, todo.ID)); err != nil {
+				return makeAndLogErrorResponse("Could not record the tweeted todo in the state store", "state_store_error", logger), err
+			}
+		}
+		if timeline != nil {
+			timeline.add(twitterAccount, plannedTweet.Text)
+		}
+		if tweetMode == TWEET_MODE_BURST_THREAD && tweetID != "" {
+			burstThreadTweetIDs[twitterAccount.UserID] = tweetID
+		}
+		if cfg.ReplyToTweetID != "" && tweetID != "" {
+			replyThreadTweetIDs[twitterAccount.UserID] = tweetID
+		}
+		if projectThreads && tweetID != "" {
+			if err := stateStore.PutValue(context.WithoutCancel(ctx), threadKey, tweetID); err != nil {
+				return makeAndLogErrorResponse("Could not record the project's thread in the state store", "state_store_error", logger), err
+			}
+		}
+		if translator != nil && tweetTranslation(ctx, translator, plannedTweet, tweetID, twitterAccount, cfg, logger) {
+			numTranslationsTweeted++
+		}
+
+		if discordClient != nil && cfg.twitterEnabled() {
+			numPosts, err := postToDiscord(discordClient, plannedTweet, cfg, logger)
+			numDiscordPosts += numPosts
 			if err != nil {
-				return makeAndLogErrorResponse("Error creating a tweet", "twitter_create_tweet_error", logger), err
+				return makeAndLogErrorResponse("Error posting to Discord", "discord_post_error", logger), err
 			}
-			logger.Info("Tweet sent successfully")
-			numTodosTweeted++
+		}
+
+		if outboundWebhookClient != nil {
+			postToOutboundWebhook(outboundWebhookClient, plannedTweet, tweetID, logger)
 		}
 	}
 
-	// Return a success message
-	logger.Info(SUCCESS_MESSAGE, "num_todos_tweeted", numTodosTweeted)
-	return Response{Message: SUCCESS_MESSAGE, NumTodosTweeted: 0}, nil //TODO: numtodostweeted
-}
+	// Only forget the deferred todos once they've all gone out. If the run stopped early, the state store keeps the next
+	// run from tweeting the ones that did go out twice. Those from projects this run didn't fetch, too far back to find or
+	// for busy accounts are kept for a run that can tweet them.
+	if len(deferredTodos) > 0 && ctx.Err() == nil {
+		if err := forgetDeferredTodos(context.WithoutCancel(ctx), stateStore, handledDeferredTodos(deferredSource, deferredTodos, twitterRouter, busyAccounts)); err != nil {
+			return makeAndLogErrorResponse("Could not clear the deferred todos in the state store", "state_store_error", logger), err
+		}
+	}
 
-func setupTwitterClients(twitterAPIKey string, twitterAPIKeySecret string, twitterAccessToken string, twitterAccessTokenSecret string) (*twitter11.TwitterApi, *twitter2.Client) {
-	oauth1Config := oauth1.NewConfig(twitterAPIKey, twitterAPIKeySecret)
-	twitterHttpClient := oauth1Config.Client(oauth1.NoContext, &oauth1.Token{
-		Token:       twitterAccessToken,
-		TokenSecret: twitterAccessTokenSecret,
-	})
-	twitterHttpClient.Timeout = CONNECTION_TIMEOUT_DURATION
-	twitter11Client := twitter11.NewTwitterApiWithCredentials(twitterAccessToken, twitterAccessTokenSecret, twitterAPIKey, twitterAPIKeySecret)
-	twitter2Client := &twitter2.Client{
-		Authorizer: authorize{},
-		Client:     twitterHttpClient,
-		Host:       "https://api.twitter.com",
-	}
-	return twitter11Client, twitter2Client
-}
+	dailyStatsTweeted := false
+	defaultAccountBusy := busyAccounts[twitterRouter.defaultAccount.UserID]
+	if ctx.Err() == nil && cfg.twitterEnabled() && !defaultAccountBusy && isDailyStatsRun(cfg, now) {
+		dailyStatsTweeted, err = tweetDailyStats(ctx, stateStore, twitterRouter.defaultAccount, publicTodos, cfg, now, logger)
+		if err != nil {
+			return makeAndLogErrorResponse("Error creating the daily stats tweet", "twitter_create_tweet_error", logger), err
+		}
+	}
 
-func uploadAttachmentFromTodo(attachment lib_wip.Attachment, twitter11Client *twitter11.TwitterApi) (string, error) {
-	resp, err := http.Get(attachment.URL)
-	if err != nil {
-		return "", err
+	weeklyRecapTweeted := false
+	if ctx.Err() == nil && cfg.twitterEnabled() && !defaultAccountBusy && isWeeklyRecapRun(cfg, now) {
+		weeklyRecapTweeted, err = tweetWeeklyRecap(ctx, stateStore, twitterRouter.defaultAccount, cfg, now, logger)
+		if err != nil {
+			return makeAndLogErrorResponse("Error creating the weekly recap thread", "twitter_create_tweet_error", logger), err
+		}
 	}
 
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	if ctx.Err() != nil {
+		logger.Warn(INTERRUPTED_MESSAGE, "num_todos_tweeted", numTodosTweeted, "num_discord_posts", numDiscordPosts)
+		return Response{Message: INTERRUPTED_MESSAGE, Code: "interrupted", NumTodosTweeted: numTodosTweeted, NumDiscordPosts: numDiscordPosts, NumTranslationsTweeted: numTranslationsTweeted, NumTodosDeferred: numTodosDeferred, SkippedTodos: skippedTodos, NumAttachmentsUploaded: uploadStats.NumUploaded, AttachmentBytesUploaded: uploadStats.BytesUploaded, NumAttachmentsDeduplicated: uploadStats.NumDeduplicated, TweetMode: tweetMode}, nil
 	}
-	media, err := twitter11Client.UploadMedia(base64.StdEncoding.EncodeToString(respBytes))
-	if err != nil {
-		return "", err
+
+	// Return a success message
+	logger.Info(SUCCESS_MESSAGE, "num_todos_tweeted", numTodosTweeted, "num_discord_posts", numDiscordPosts, "daily_stats_tweeted", dailyStatsTweeted, "weekly_recap_tweeted", weeklyRecapTweeted, "num_todos_skipped", len(skippedTodos), "num_todos_deferred", numTodosDeferred, "num_attachments_uploaded", uploadStats.NumUploaded, "attachment_bytes_uploaded", uploadStats.BytesUploaded, "num_attachments_deduplicated", uploadStats.NumDeduplicated)
+	return Response{Message: SUCCESS_MESSAGE, NumTodosTweeted: numTodosTweeted, NumDiscordPosts: numDiscordPosts, NumTranslationsTweeted: numTranslationsTweeted, NumTodosDeferred: numTodosDeferred, DailyStatsTweeted: dailyStatsTweeted, WeeklyRecapTweeted: weeklyRecapTweeted, SkippedTodos: skippedTodos, NumAttachmentsUploaded: uploadStats.NumUploaded, AttachmentBytesUploaded: uploadStats.BytesUploaded, NumAttachmentsDeduplicated: uploadStats.NumDeduplicated, TweetMode: tweetMode}, nil
+}
+
+func isRunningWithoutLambda() bool {
+	return os.Getenv("RUN_WITHOUT_LAMBDA") == "true"
+}
+
+// Flags override the evars they stand for, which override the config file. Setting the evars before the config is
+// loaded gets that order, and a flag that wasn't passed leaves its evar alone.
+func setEnvFromFlags(flagValues map[string]string) {
+	for key, value := range flagValues {
+		if value != "" {
+			os.Setenv(key, value)
+		}
 	}
-	return strconv.FormatInt(media.MediaID, 10), err
 }
 
 func main() {
+	configFile := flag.String("config", "", "Path to a YAML or JSON config file, same as setting CONFIG_FILE")
+	replayTodoID := flag.String("replay-todo-id", "", "Tweet this todo right away, whenever it was completed and even if it was tweeted before")
+	replayRecord := flag.Bool("replay-record", false, "With -replay-todo-id, also record the todo as tweeted in the state store")
+	projectFilter := flag.String("project", "", "Only tweet todos from the project with this ID or name, same as setting PROJECT_FILTER")
+	replyTo := flag.String("reply-to", "", "Tweet the todos as replies to the tweet with this ID, same as setting REPLY_TO_TWEET_ID")
+	interactive := flag.Bool("interactive", false, "Ask before each tweet, with the option to edit it, same as setting INTERACTIVE")
+	drain := flag.Bool("drain", false, "Only post the queued todos that are due, same as setting DRAIN_QUEUE")
+	approveTodoIDs := flag.String("approve", "", "Approve these comma separated todo IDs in the approval queue, for the next run to tweet")
+	rejectTodoIDs := flag.String("reject", "", "Reject these comma separated todo IDs in the approval queue, so they're never tweeted")
+	listApprovals := flag.Bool("list-approvals", false, "Log the todos in the approval queue and what they'd be tweeted as")
+	flag.Parse()
+
 	godotenv.Load()
-	if os.Getenv("RUN_WITHOUT_LAMBDA") == "true" {
-		Handler(context.TODO())
+	interactiveEnv := ""
+	if *interactive {
+		interactiveEnv = "true"
+	}
+	drainEnv := ""
+	if *drain {
+		drainEnv = "true"
+	}
+	setEnvFromFlags(map[string]string{
+		"CONFIG_FILE":       *configFile,
+		"PROJECT_FILTER":    *projectFilter,
+		"REPLY_TO_TWEET_ID": *replyTo,
+		"INTERACTIVE":       interactiveEnv,
+		"DRAIN_QUEUE":       drainEnv,
+	})
+	if isRunningWithoutLambda() && os.Getenv("PREVIEW_SERVER") == "true" {
+		logger := newLogger()
+		if err := startPreviewServer(logger); err != nil {
+			logger.Error("Preview server stopped", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := setupTracing(context.Background()); err != nil {
+		newLogger().Error("Could not set up tracing, continuing without it", "error", err)
+	}
+
+	if isRunningWithoutLambda() {
+		// Finish the tweet in flight and exit cleanly on SIGTERM/SIGINT instead of dying mid-run
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		if *replayTodoID != "" {
+			replayTodo(ctx, *replayTodoID, *replayRecord)
+		} else if *approveTodoIDs != "" {
+			reviewApprovalQueue(ctx, strings.Split(*approveTodoIDs, ","), APPROVAL_STATE_APPROVED)
+		} else if *rejectTodoIDs != "" {
+			reviewApprovalQueue(ctx, strings.Split(*rejectTodoIDs, ","), APPROVAL_STATE_REJECTED)
+		} else if *listApprovals {
+			reviewApprovalQueue(ctx, nil, "")
+		} else {
+			Handler(ctx)
+		}
 	} else {
 		lambda.Start(Handler)
 	}
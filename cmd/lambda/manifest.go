@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// runManifest is the record of a run written to RUN_MANIFEST_S3_PREFIX
+type runManifest struct {
+	RunID        string        `json:"run_id"`
+	StartedAt    time.Time     `json:"started_at"`
+	FinishedAt   time.Time     `json:"finished_at"`
+	Response     Response      `json:"response"`
+	Error        string        `json:"error,omitempty"`
+	TweetedTodos []tweetedTodo `json:"tweeted_todos"`
+}
+
+type tweetedTodo struct {
+	ProjectID string    `json:"project_id"`
+	TodoID    string    `json:"todo_id"`
+	TweetID   string    `json:"tweet_id"`
+	TweetedAt time.Time `json:"tweeted_at"`
+}
+
+// Splits a prefix like "s3://my-bucket/runs/" into its bucket and key prefix
+func parseS3Prefix(prefix string) (string, string, error) {
+	bucketAndKey, ok := strings.CutPrefix(prefix, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("expected an s3:// URL, got %q", prefix)
+	}
+	bucket, keyPrefix, _ := strings.Cut(bucketAndKey, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("no bucket in %q", prefix)
+	}
+	return bucket, keyPrefix, nil
+}
+
+// The manifest is a record of the run rather than part of it, so failing to write it is only logged
+func writeRunManifest(ctx context.Context, s3Prefix string, manifest runManifest, logger *slog.Logger) {
+	bucket, keyPrefix, err := parseS3Prefix(s3Prefix)
+	if err != nil {
+		logger.Error("Could not write the run manifest", "error", err)
+		return
+	}
+	// Timestamped keys sort in the order the runs happened
+	key := keyPrefix + manifest.StartedAt.Format("2006/01/02/150405") + "-" + manifest.RunID + ".json"
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		logger.Error("Could not marshal the run manifest", "error", err)
+		return
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		logger.Error("Could not load AWS config to write the run manifest", "error", err)
+		return
+	}
+	_, err = s3.NewFromConfig(awsConfig).PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(manifestBytes),
+		ContentType: aws.String(CONTENT_TYPE_APPLICATION_JSON),
+	})
+	if err != nil {
+		logger.Error("Could not write the run manifest", "bucket", bucket, "key", key, "error", err)
+		return
+	}
+	logger.Info("Wrote the run manifest", "bucket", bucket, "key", key)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+)
+
+// Twitter expires uploaded media after a day, so cached media IDs are dropped a little before that
+const MEDIA_ID_CACHE_TTL = 23 * time.Hour
+
+// cachedMedia is what was uploaded for a todo whose tweet hasn't gone out yet
+type cachedMedia struct {
+	todoMedia
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// mediaCache keeps the media uploaded for a todo until its tweet is sent, so if creating the tweet fails the next
+// attempt reuses the media rather than uploading it all again. The cache is only an optimization, so failing to read
+// or write it is logged rather than failing the todo. A nil mediaCache caches nothing.
+type mediaCache struct {
+	stateStore lib_state.Store
+}
+
+func newMediaCache(stateStore lib_state.Store) *mediaCache {
+	return &mediaCache{stateStore: stateStore}
+}
+
+// Media is uploaded as a particular user, so like processed todos the cache is per account
+func mediaCacheKey(account *twitterAccount, todoID string) string {
+	return "media#" + account.UserID + "#" + todoID
+}
+
+// Returns the media IDs uploaded for the todo by an earlier attempt, if they're still usable
+func (c *mediaCache) load(ctx context.Context, account *twitterAccount, todoID string, now time.Time, logger *slog.Logger) (todoMedia, bool) {
+	if c == nil {
+		return todoMedia{}, false
+	}
+	value, err := c.stateStore.GetValue(ctx, mediaCacheKey(account, todoID))
+	if err != nil {
+		logger.Warn("Could not read the cached media IDs, uploading the media again", "todo_id", todoID, "error", err)
+		return todoMedia{}, false
+	}
+	if value == "" {
+		return todoMedia{}, false
+	}
+	cached := cachedMedia{}
+	if err := json.Unmarshal([]byte(value), &cached); err != nil {
+		logger.Warn("Could not unmarshal the cached media IDs, uploading the media again", "todo_id", todoID, "error", err)
+		return todoMedia{}, false
+	}
+	if now.Sub(cached.UploadedAt) > MEDIA_ID_CACHE_TTL {
+		return todoMedia{}, false
+	}
+	return cached.todoMedia, true
+}
+
+func (c *mediaCache) save(ctx context.Context, account *twitterAccount, todoID string, media todoMedia, now time.Time, logger *slog.Logger) {
+	if c == nil || len(media.MediaIDs) == 0 {
+		return
+	}
+	value, err := json.Marshal(cachedMedia{todoMedia: media, UploadedAt: now})
+	if err == nil {
+		err = c.stateStore.PutValue(ctx, mediaCacheKey(account, todoID), string(value))
+	}
+	if err != nil {
+		logger.Warn("Could not cache the uploaded media IDs", "todo_id", todoID, "error", err)
+	}
+}
+
+// Called once the tweet is out, when the media can't be used again anyway
+func (c *mediaCache) clear(ctx context.Context, account *twitterAccount, todoID string, logger *slog.Logger) {
+	if c == nil {
+		return
+	}
+	if err := c.stateStore.DeleteValue(ctx, mediaCacheKey(account, todoID)); err != nil {
+		logger.Warn("Could not clear the cached media IDs", "todo_id", todoID, "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// What Twitter accepts, so by default nothing is uploaded just to be rejected or, like a PDF, to turn into a broken tweet
+var DEFAULT_ALLOWED_MEDIA_TYPES = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "video/mp4", "video/quicktime"}
+
+var errMediaTypeNotAllowed = errors.New("media type not allowed")
+
+// Checks content types against MIME type prefixes, e.g. "image/" or "image/png". A denied prefix wins over an allowed one,
+// and an empty allow list allows everything that isn't denied.
+type mediaTypeFilter struct {
+	allowed []string
+	denied  []string
+}
+
+func (f mediaTypeFilter) allows(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, prefix := range f.denied {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	if len(f.allowed) == 0 {
+		return true
+	}
+	for _, prefix := range f.allowed {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeMediaTypes(mediaTypes []string) []string {
+	normalized := []string{}
+	for _, mediaType := range mediaTypes {
+		if mediaType = strings.ToLower(strings.TrimSpace(mediaType)); mediaType != "" {
+			normalized = append(normalized, mediaType)
+		}
+	}
+	return normalized
+}
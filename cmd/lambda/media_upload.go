@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MEDIA_UPLOAD_URL = "https://upload.twitter.com/1.1/media/upload.json"
+	// Twitter takes chunks of up to 5MB
+	MEDIA_UPLOAD_CHUNK_SIZE = 4 * 1024 * 1024
+	// Videos are processed after they're uploaded, and can't be tweeted until that's done
+	MAX_MEDIA_PROCESSING_WAIT = 5 * time.Minute
+	// How long before the run's deadline to give up waiting, leaving time to report the failure
+	MEDIA_PROCESSING_DEADLINE_MARGIN = 10 * time.Second
+	// The largest file Twitter takes for each media category
+	MAX_IMAGE_BYTES = 5 * 1024 * 1024
+	MAX_GIF_BYTES   = 15 * 1024 * 1024
+	MAX_VIDEO_BYTES = 512 * 1024 * 1024
+)
+
+// What to do with an attachment that's over Twitter's size limit
+const (
+	// Treat it like any other attachment that fails to upload, so FALLBACK_ATTACHMENT_URL replaces it if set
+	OVERSIZE_POLICY_FAIL = "fail"
+	OVERSIZE_POLICY_SKIP = "skip"
+	// Put a link to it in the tweet instead
+	OVERSIZE_POLICY_LINK = "link"
+)
+
+var errMediaTooLarge = errors.New("media is over Twitter's size limit")
+
+type mediaUploadResponse struct {
+	MediaIDString  string               `json:"media_id_string"`
+	ProcessingInfo *mediaProcessingInfo `json:"processing_info"`
+}
+
+type mediaProcessingInfo struct {
+	State          string `json:"state"`
+	CheckAfterSecs int    `json:"check_after_secs"`
+	Error          *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Extensions of media types http.DetectContentType can't sniff, which the system's MIME table may not know either
+var mediaTypesByExtension = map[string]string{
+	".mov": "video/quicktime",
+	".qt":  "video/quicktime",
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+}
+
+// Sniffs the media's type from its bytes. http.DetectContentType doesn't recognise every video container, QuickTime
+// .mov files among them, so when it can't tell the type is taken from the extension of the URL the media came from.
+func detectMediaType(mediaBytes []byte, sourceURL string) string {
+	contentType := http.DetectContentType(mediaBytes)
+	if contentType != "application/octet-stream" {
+		return contentType
+	}
+	parsedURL, err := url.Parse(sourceURL)
+	if err != nil {
+		return contentType
+	}
+	extension := strings.ToLower(path.Ext(parsedURL.Path))
+	if mediaType, ok := mediaTypesByExtension[extension]; ok {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(extension)); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// Uploads media to Twitter, see uploadMediaTo
+func uploadMedia(ctx context.Context, mediaBytes []byte, contentType string, mediaHttpClient *http.Client) (string, error) {
+	return uploadMediaTo(ctx, MEDIA_UPLOAD_URL, mediaBytes, contentType, mediaHttpClient)
+}
+
+// Uploads media with the chunked INIT/APPEND/FINALIZE flow, sending the file as binary rather than base64, and waits for
+// Twitter to finish processing it if it needs to. Returns the media ID to attach to a tweet.
+func uploadMediaTo(ctx context.Context, uploadURL string, mediaBytes []byte, contentType string, mediaHttpClient *http.Client) (string, error) {
+	initResp, err := postMediaForm(ctx, mediaHttpClient, uploadURL, url.Values{
+		"command":        {"INIT"},
+		"total_bytes":    {strconv.Itoa(len(mediaBytes))},
+		"media_type":     {contentType},
+		"media_category": {mediaCategory(contentType)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("media upload INIT failed: %w", err)
+	}
+	mediaID := initResp.MediaIDString
+
+	for segmentIndex := 0; segmentIndex*MEDIA_UPLOAD_CHUNK_SIZE < len(mediaBytes); segmentIndex++ {
+		chunk := mediaBytes[segmentIndex*MEDIA_UPLOAD_CHUNK_SIZE : min((segmentIndex+1)*MEDIA_UPLOAD_CHUNK_SIZE, len(mediaBytes))]
+		if err := appendMediaChunk(ctx, mediaHttpClient, uploadURL, mediaID, segmentIndex, chunk); err != nil {
+			return "", fmt.Errorf("media upload APPEND of segment %d failed: %w", segmentIndex, err)
+		}
+	}
+
+	finalizeResp, err := postMediaForm(ctx, mediaHttpClient, uploadURL, url.Values{"command": {"FINALIZE"}, "media_id": {mediaID}})
+	if err != nil {
+		return "", fmt.Errorf("media upload FINALIZE failed: %w", &writeError{err: err})
+	}
+	if err := waitForMediaProcessing(ctx, mediaHttpClient, uploadURL, mediaID, finalizeResp.ProcessingInfo); err != nil {
+		return "", err
+	}
+	return mediaID, nil
+}
+
+func mediaCategory(contentType string) string {
+	switch {
+	case contentType == "image/gif":
+		return "tweet_gif"
+	case strings.HasPrefix(contentType, "video/"):
+		return "tweet_video"
+	default:
+		return "tweet_image"
+	}
+}
+
+func maxMediaBytes(category string) int {
+	switch category {
+	case "tweet_gif":
+		return MAX_GIF_BYTES
+	case "tweet_video":
+		return MAX_VIDEO_BYTES
+	default:
+		return MAX_IMAGE_BYTES
+	}
+}
+
+func postMediaForm(ctx context.Context, mediaHttpClient *http.Client, uploadURL string, form url.Values) (*mediaUploadResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doMediaRequest(mediaHttpClient, req)
+}
+
+// The chunk goes in a multipart body, which isn't part of the OAuth1 signature, so neither are the other fields
+func appendMediaChunk(ctx context.Context, mediaHttpClient *http.Client, uploadURL string, mediaID string, segmentIndex int, chunk []byte) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("command", "APPEND")
+	writer.WriteField("media_id", mediaID)
+	writer.WriteField("segment_index", strconv.Itoa(segmentIndex))
+	part, err := writer.CreateFormFile("media", "media")
+	if err != nil {
+		return err
+	}
+	part.Write(chunk)
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	_, err = doMediaRequest(mediaHttpClient, req)
+	return err
+}
+
+func waitForMediaProcessing(ctx context.Context, mediaHttpClient *http.Client, uploadURL string, mediaID string, processingInfo *mediaProcessingInfo) error {
+	deadline := time.Now().Add(MAX_MEDIA_PROCESSING_WAIT)
+	if runDeadline, ok := ctx.Deadline(); ok && runDeadline.Add(-MEDIA_PROCESSING_DEADLINE_MARGIN).Before(deadline) {
+		deadline = runDeadline.Add(-MEDIA_PROCESSING_DEADLINE_MARGIN)
+	}
+	for processingInfo != nil {
+		switch processingInfo.State {
+		case "succeeded":
+			return nil
+		case "failed":
+			message := "unknown error"
+			if processingInfo.Error != nil {
+				message = processingInfo.Error.Message
+			}
+			return fmt.Errorf("twitter could not process the media: %s", message)
+		}
+		checkAfter := time.Duration(max(processingInfo.CheckAfterSecs, 1)) * time.Second
+		if time.Now().Add(checkAfter).After(deadline) {
+			return fmt.Errorf("twitter was still processing the media when the wait ran out, after at most %s or just before the run's deadline", MAX_MEDIA_PROCESSING_WAIT)
+		}
+		timer := time.NewTimer(checkAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uploadURL+"?"+url.Values{"command": {"STATUS"}, "media_id": {mediaID}}.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		statusResp, err := doMediaRequest(mediaHttpClient, req)
+		if err != nil {
+			return fmt.Errorf("media upload STATUS failed: %w", err)
+		}
+		processingInfo = statusResp.ProcessingInfo
+	}
+	return nil
+}
+
+func doMediaRequest(mediaHttpClient *http.Client, req *http.Request) (*mediaUploadResponse, error) {
+	resp, err := mediaHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("twitter returned status code %d: %s", resp.StatusCode, respBytes)
+	}
+	// APPEND answers with an empty body
+	uploadResp := &mediaUploadResponse{}
+	if len(bytes.TrimSpace(respBytes)) > 0 {
+		if err := json.Unmarshal(respBytes, uploadResp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the response: %w", err)
+		}
+	}
+	return uploadResp, nil
+}
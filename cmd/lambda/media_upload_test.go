@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+// fakeMediaUpload stands in for Twitter's media upload endpoint and records what it was sent
+type fakeMediaUpload struct {
+	mu        sync.Mutex
+	commands  []string
+	mediaType string
+	category  string
+	segments  map[string][]byte
+	// Answers to FINALIZE and then each STATUS, in order
+	processingStates []string
+	// Commands that fail with the status code
+	failures map[string]int
+}
+
+func (f *fakeMediaUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	command := r.URL.Query().Get("command")
+	if r.Method == http.MethodPost {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(MEDIA_UPLOAD_CHUNK_SIZE * 2); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		command = r.FormValue("command")
+	}
+	f.commands = append(f.commands, command)
+	if statusCode, ok := f.failures[command]; ok {
+		http.Error(w, `{"errors":[{"message":"nope"}]}`, statusCode)
+		return
+	}
+
+	switch command {
+	case "INIT":
+		f.mediaType = r.FormValue("media_type")
+		f.category = r.FormValue("media_category")
+		fmt.Fprint(w, `{"media_id_string":"123"}`)
+	case "APPEND":
+		file, _, err := r.FormFile("media")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chunk, _ := io.ReadAll(file)
+		f.segments[r.FormValue("segment_index")] = chunk
+		w.WriteHeader(http.StatusNoContent)
+	case "FINALIZE", "STATUS":
+		if len(f.processingStates) == 0 {
+			fmt.Fprint(w, `{"media_id_string":"123"}`)
+			return
+		}
+		state := f.processingStates[0]
+		f.processingStates = f.processingStates[1:]
+		if state == "failed" {
+			fmt.Fprint(w, `{"media_id_string":"123","processing_info":{"state":"failed","error":{"message":"bad video"}}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"media_id_string":"123","processing_info":{"state":%q,"check_after_secs":0}}`, state)
+	}
+}
+
+func newFakeMediaUpload(t *testing.T) (*fakeMediaUpload, string) {
+	fake := &fakeMediaUpload{segments: map[string][]byte{}, failures: map[string]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func TestUploadMediaChunks(t *testing.T) {
+	fake, uploadURL := newFakeMediaUpload(t)
+	media := bytes.Repeat([]byte("x"), MEDIA_UPLOAD_CHUNK_SIZE+10)
+
+	mediaID, err := uploadMediaTo(context.Background(), uploadURL, media, "image/png", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaID != "123" {
+		t.Errorf("got media ID %q, want 123", mediaID)
+	}
+	if got := strings.Join(fake.commands, ","); got != "INIT,APPEND,APPEND,FINALIZE" {
+		t.Errorf("got commands %s, want INIT,APPEND,APPEND,FINALIZE", got)
+	}
+	if fake.mediaType != "image/png" || fake.category != "tweet_image" {
+		t.Errorf("got media type %q in category %q, want image/png in tweet_image", fake.mediaType, fake.category)
+	}
+	if len(fake.segments["0"]) != MEDIA_UPLOAD_CHUNK_SIZE || len(fake.segments["1"]) != 10 {
+		t.Errorf("got segments of %d and %d bytes, want %d and 10", len(fake.segments["0"]), len(fake.segments["1"]), MEDIA_UPLOAD_CHUNK_SIZE)
+	}
+	if !bytes.Equal(append(fake.segments["0"], fake.segments["1"]...), media) {
+		t.Errorf("the segments don't add up to the media")
+	}
+}
+
+func TestUploadMediaWaitsForProcessing(t *testing.T) {
+	fake, uploadURL := newFakeMediaUpload(t)
+	fake.processingStates = []string{"pending", "in_progress", "succeeded"}
+
+	if _, err := uploadMediaTo(context.Background(), uploadURL, []byte("video"), "video/mp4", http.DefaultClient); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(fake.commands, ","); got != "INIT,APPEND,FINALIZE,STATUS,STATUS" {
+		t.Errorf("got commands %s, want INIT,APPEND,FINALIZE,STATUS,STATUS", got)
+	}
+	if fake.category != "tweet_video" {
+		t.Errorf("got category %q, want tweet_video", fake.category)
+	}
+}
+
+func TestUploadMediaStopsWaitingBeforeTheDeadline(t *testing.T) {
+	fake, uploadURL := newFakeMediaUpload(t)
+	fake.processingStates = []string{"pending", "succeeded"}
+	ctx, cancel := context.WithTimeout(context.Background(), MEDIA_PROCESSING_DEADLINE_MARGIN+500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := uploadMediaTo(ctx, uploadURL, []byte("video"), "video/mp4", http.DefaultClient)
+	if err == nil || !strings.Contains(err.Error(), "still processing") {
+		t.Errorf("got error %v, want one saying the media was still processing", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %s, past the point the run's deadline leaves time for", elapsed)
+	}
+	if got := strings.Join(fake.commands, ","); got != "INIT,APPEND,FINALIZE" {
+		t.Errorf("got commands %s, want INIT,APPEND,FINALIZE", got)
+	}
+}
+
+func TestUploadMediaProcessingFailed(t *testing.T) {
+	fake, uploadURL := newFakeMediaUpload(t)
+	fake.processingStates = []string{"pending", "failed"}
+
+	_, err := uploadMediaTo(context.Background(), uploadURL, []byte("video"), "video/mp4", http.DefaultClient)
+	if err == nil || !strings.Contains(err.Error(), "bad video") {
+		t.Errorf("got error %v, want the processing error", err)
+	}
+}
+
+func TestUploadMediaErrorStatuses(t *testing.T) {
+	for _, command := range []string{"INIT", "APPEND", "FINALIZE"} {
+		t.Run(command, func(t *testing.T) {
+			fake, uploadURL := newFakeMediaUpload(t)
+			fake.failures[command] = http.StatusBadRequest
+
+			_, err := uploadMediaTo(context.Background(), uploadURL, []byte("image"), "image/png", http.DefaultClient)
+			if err == nil || !strings.Contains(err.Error(), command) || !strings.Contains(err.Error(), "400") {
+				t.Errorf("got error %v, want one saying %s failed with 400", err, command)
+			}
+			if got := fake.commands[len(fake.commands)-1]; got != command {
+				t.Errorf("kept going after %s failed, last command was %s", command, got)
+			}
+		})
+	}
+}
+
+// Sends every request to the fake upload server, whatever Twitter URL it was for
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestUploadTodoMediaOversizePolicy(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	attachments := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/huge.png" {
+			w.Write(append(png, bytes.Repeat([]byte("x"), MAX_IMAGE_BYTES)...))
+			return
+		}
+		w.Write(png)
+	}))
+	t.Cleanup(attachments.Close)
+	hugeURL := attachments.URL + "/huge.png"
+	fallbackURL := attachments.URL + "/fallback.png"
+
+	tests := []struct {
+		name        string
+		policy      string
+		fallbackURL string
+		wantUpload  bool
+		wantLinks   []string
+		wantErr     bool
+	}{
+		{"fail", OVERSIZE_POLICY_FAIL, "", false, nil, true},
+		{"fail with fallback", OVERSIZE_POLICY_FAIL, fallbackURL, true, nil, false},
+		{"skip with fallback", OVERSIZE_POLICY_SKIP, fallbackURL, false, nil, false},
+		{"link with fallback", OVERSIZE_POLICY_LINK, fallbackURL, false, []string{hugeURL}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, uploadURL := newFakeMediaUpload(t)
+			target, _ := url.Parse(uploadURL)
+			httpClient := &http.Client{Transport: redirectTransport{target: target}}
+			account := &twitterAccount{mediaHttpClient: httpClient, twitter2Client: &twitter2.Client{Client: httpClient}, mediaIDsByHash: map[string]string{}}
+			cfg := defaultConfig()
+			cfg.OversizePolicy = test.policy
+			cfg.FallbackAttachmentURL = test.fallbackURL
+			plannedTweet := plannedTweet{Todo: lib_wip.Todo{ID: "t1"}, Attachments: []lib_wip.Attachment{{URL: hugeURL}}}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			media, err := uploadTodoMedia(context.Background(), plannedTweet, account, lib_media.NewDownloader(time.Second, 0), cfg, &attachmentStats{}, logger)
+			if test.wantErr {
+				if !errors.Is(err, errMediaTooLarge) {
+					t.Errorf("got error %v, want errMediaTooLarge", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploaded := slices.Contains(fake.commands, "INIT")
+			if uploaded != test.wantUpload {
+				t.Errorf("uploaded the fallback image: %v, want %v", uploaded, test.wantUpload)
+			}
+			if uploaded && !slices.Equal(media.MediaIDs, []string{"123"}) {
+				t.Errorf("got media IDs %v, want the fallback image's", media.MediaIDs)
+			}
+			if !uploaded && len(media.MediaIDs) > 0 {
+				t.Errorf("got media IDs %v, want none", media.MediaIDs)
+			}
+			if !slices.Equal(media.OversizeLinks, test.wantLinks) {
+				t.Errorf("got oversize links %v, want %v", media.OversizeLinks, test.wantLinks)
+			}
+		})
+	}
+}
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		media     []byte
+		sourceURL string
+		want      string
+	}{
+		{[]byte("\x89PNG\r\n\x1a\n"), "https://example.com/image.mov", "image/png"},
+		{[]byte("\x00\x00\x00\x14ftypqt  "), "https://example.com/clip.MOV?size=large", "video/quicktime"},
+		{[]byte("\x00\x00\x00\x14ftypqt  "), "https://example.com/clip", "application/octet-stream"},
+		{[]byte("\x00\x00\x00\x14ftypqt  "), "https://example.com/photo.png", "image/png"},
+	}
+	for _, test := range tests {
+		if got := detectMediaType(test.media, test.sourceURL); got != test.want {
+			t.Errorf("detectMediaType(%q, %q) = %q, want %q", test.media, test.sourceURL, got, test.want)
+		}
+	}
+}
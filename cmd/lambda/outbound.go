@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	lib_webhook "github.com/bakatz/wip-to-twitter-bridge/lib/webhook"
+)
+
+// Sent with every payload, so receivers can tell it apart from anything else that might be sent later
+const OUTBOUND_WEBHOOK_EVENT_TODO_POSTED = "todo_posted"
+
+// outboundWebhookPayload is what's POSTed to OUTBOUND_WEBHOOK_URL for each todo that went out
+type outboundWebhookPayload struct {
+	Event   string                 `json:"event"`
+	Todo    outboundWebhookTodo    `json:"todo"`
+	Project outboundWebhookProject `json:"project"`
+	// The text that was tweeted, which packed todos share
+	Text      string   `json:"text"`
+	MediaURLs []string `json:"media_urls"`
+	// Empty when only posting to Discord
+	TweetID  string    `json:"tweet_id,omitempty"`
+	PostedAt time.Time `json:"posted_at"`
+}
+
+type outboundWebhookTodo struct {
+	ID          string    `json:"id"`
+	Body        string    `json:"body"`
+	URL         string    `json:"url"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type outboundWebhookProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	URL  string `json:"url"`
+}
+
+// Sends one payload per todo in the planned tweet. The todo is already out and recorded by now, so a payload that
+// fails to send is logged rather than failing the run.
+func postToOutboundWebhook(webhookClient *lib_webhook.Client, plannedTweet plannedTweet, tweetID string, logger *slog.Logger) {
+	project := outboundWebhookProject{ID: plannedTweet.Project.ID, Name: plannedTweet.Project.Name, Slug: plannedTweet.Project.Slug, URL: plannedTweet.Project.URL}
+	for i, todo := range plannedTweet.todos() {
+		attachments := todo.Attachments
+		if i == 0 {
+			attachments = plannedTweet.Attachments
+		}
+		mediaURLs := []string{}
+		for _, attachment := range attachments {
+			mediaURLs = append(mediaURLs, attachment.URL)
+		}
+		payload := outboundWebhookPayload{
+			Event:     OUTBOUND_WEBHOOK_EVENT_TODO_POSTED,
+			Todo:      outboundWebhookTodo{ID: todo.ID, Body: todo.Body, URL: todo.URL, CompletedAt: todo.CreatedAt},
+			Project:   project,
+			Text:      plannedTweet.Text,
+			MediaURLs: mediaURLs,
+			TweetID:   tweetID,
+			PostedAt:  time.Now().UTC(),
+		}
+		if err := webhookClient.Post(payload); err != nil {
+			logger.Warn("Could not send the todo to the outbound webhook", "todo_id", todo.ID, "error", err)
+			continue
+		}
+		logger.Info("Sent the todo to the outbound webhook", "todo_id", todo.ID)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// All of the todos a planned tweet covers, which is more than one when todos were packed together
+func (p plannedTweet) todos() []lib_wip.Todo {
+	return append([]lib_wip.Todo{p.Todo}, p.PackedTodos...)
+}
+
+// Polls, overflow images and code images need the tweet to themselves, and so does a todo's own changelog link
+func isPackable(plannedTweet plannedTweet) bool {
+	return len(plannedTweet.PollOptions) == 0 && plannedTweet.TextImageBody == "" && len(plannedTweet.CodeBlocks) == 0 && plannedTweet.ChangelogURL == ""
+}
+
+// Packs consecutive todos from the same project into as few tweets as possible, one line per todo. Todos stay in the
+// order they were planned in, and a pack keeps the first todo's attachments and settings.
+func packPlannedTweets(plannedTweets []plannedTweet, hashtagPosition string) []plannedTweet {
+	packed := []plannedTweet{}
+	for _, plannedTweet := range plannedTweets {
+		if len(packed) > 0 {
+			pack := &packed[len(packed)-1]
+			if pack.Project.ID == plannedTweet.Project.ID && isPackable(*pack) && isPackable(plannedTweet) {
+				packBody := strings.TrimSpace(pack.Body) + "\n" + CHECKMARK_PREFIX + strings.TrimSpace(plannedTweet.Body)
+				packHashtags := dedupeHashtags(append(append([]string{}, pack.Hashtags...), plannedTweet.Hashtags...))
+				// The first hashtag always makes it in, so only that one has to fit
+				if tweetLength(buildTweetMessage(packBody, packHashtags[:min(len(packHashtags), 1)], hashtagPosition)) <= MAX_TWEET_LENGTH {
+					pack.Body = packBody
+					pack.Hashtags = packHashtags
+					pack.Text = buildTweetMessage(packBody, packHashtags, hashtagPosition)
+					pack.PackedTodos = append(pack.PackedTodos, plannedTweet.Todo)
+					// Todos merged into this one go out with the pack, so they're marked as processed with it
+					pack.MergedTodos = append(pack.MergedTodos, plannedTweet.MergedTodos...)
+					continue
+				}
+			}
+		}
+		packed = append(packed, plannedTweet)
+	}
+	return packed
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// plannedTweet is everything needed to post a single todo, worked out before anything gets posted
+type plannedTweet struct {
+	Project  lib_wip.Project
+	Todo     lib_wip.Todo
+	Hashtags []string
+	// The part of the todo's body that made it into Text
+	Body          string
+	Text          string
+	ReplySettings string
+	// Set when the body didn't fit in a tweet, in which case it gets attached as an image
+	TextImageBody string
+	Attachments   []lib_wip.Attachment
+	// Set when the tweet continues a thread
+	InReplyToTweetID string
+	// Set when the todo asked to be tweeted as a poll
+	PollOptions []string
+	// Other todos tweeted along with Todo when packing todos
+	PackedTodos []lib_wip.Todo
+	// Code taken out of the body to be attached as syntax highlighted images
+	CodeBlocks []codeBlock
+	// Todos that said work on Todo had started, which aren't tweeted but are marked as processed along with it
+	MergedTodos []lib_wip.Todo
+	// Goes on its own line at the end of the tweet, which Text leaves room for
+	ChangelogURL string
+}
+
+// How long Text can be, leaving room for the changelog link
+func (p plannedTweet) maxTextLength() int {
+	if p.ChangelogURL != "" {
+		return MAX_TWEET_LENGTH - 1 - TCO_URL_LENGTH
+	}
+	return MAX_TWEET_LENGTH
+}
+
+func newWIPClient(wipAPIKey string, cfg Config, logger *slog.Logger) (*lib_wip.Client, error) {
+	wipClient := lib_wip.NewClient(wipAPIKey)
+	wipClient.SetLogger(logger)
+	// validate has already checked the scheme
+	wipClient.SetAuthScheme(cfg.WIPAuthScheme)
+
+	// The response cache is strictly a local debugging aid, so never use it when running in Lambda
+	if cfg.WIPCacheFile != "" && isRunningWithoutLambda() {
+		if err := wipClient.EnableCache(cfg.WIPCacheFile, time.Duration(cfg.WIPCacheTTLMinutes)*time.Minute); err != nil {
+			return nil, err
+		}
+		logger.Info("Using the WIP response cache", "file", cfg.WIPCacheFile, "ttl_minutes", cfg.WIPCacheTTLMinutes)
+	}
+	return wipClient, nil
+}
+
+type projectWithTodos struct {
+	Project lib_wip.Project
+	Todos   []lib_wip.Todo
+	// The todo to fetch the next page of the project's todos after, empty once they've all been fetched
+	NextPageAfter string
+}
+
+// Leaves out the todos marked as private, and works out where the next page starts if there is one
+func addPublicTodos(projectTodos *projectWithTodos, todos *lib_wip.PaginatedTodos) {
+	for _, todo := range todos.Data {
+		// Also skip private todos that should not be replicated to twitter.
+		if strings.Contains(todo.Body, PRIVATE_ENTITY_IDENTIFIER) {
+			continue
+		}
+		projectTodos.Todos = append(projectTodos.Todos, todo)
+	}
+	projectTodos.NextPageAfter = ""
+	if todos.HasMore && len(todos.Data) > 0 {
+		projectTodos.NextPageAfter = todos.Data[len(todos.Data)-1].ID
+	}
+}
+
+// Gets all of the projects and todos from wip.co that aren't marked as private
+func fetchPublicTodos(wipClient *lib_wip.Client) ([]projectWithTodos, error) {
+	projectsLimit := 100
+	projects, err := wipClient.GetMyProjects(&projectsLimit, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not call GetMyProjects: %w", err)
+	}
+
+	publicTodos := []projectWithTodos{}
+	for _, project := range projects.Data {
+		// Skip replicating all todos in projects marked as "private"
+		if strings.Contains(project.Pitch, PRIVATE_ENTITY_IDENTIFIER) {
+			continue
+		}
+
+		todos, err := wipClient.GetProjectTodos(project.ID, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not get project todos: %w", err)
+		}
+
+		projectTodos := projectWithTodos{Project: project}
+		addPublicTodos(&projectTodos, todos)
+		publicTodos = append(publicTodos, projectTodos)
+	}
+	return publicTodos, nil
+}
+
+// Keeps only the project whose ID is the filter, or failing that whose name is the filter ignoring case
+func filterProjects(publicTodos []projectWithTodos, filter string) ([]projectWithTodos, error) {
+	for _, projectTodos := range publicTodos {
+		if projectTodos.Project.ID == filter {
+			return []projectWithTodos{projectTodos}, nil
+		}
+	}
+	for _, projectTodos := range publicTodos {
+		if strings.EqualFold(projectTodos.Project.Name, filter) {
+			return []projectWithTodos{projectTodos}, nil
+		}
+	}
+	return nil, fmt.Errorf("no public project has the ID or name %q", filter)
+}
+
+// skippedTodo is a todo within the window that was deliberately not tweeted
+type skippedTodo struct {
+	ProjectID string `json:"project_id"`
+	TodoID    string `json:"todo_id"`
+	Reason    string `json:"reason"`
+	// For near duplicates, the recently tweeted body this todo was too similar to
+	MatchedBody string `json:"matched_body,omitempty"`
+}
+
+// Works out what to tweet for each of the todos completed within the window, and which of them to skip
+func planTweets(publicTodos []projectWithTodos, cfg Config, window lookbackWindow, logger *slog.Logger) ([]plannedTweet, []skippedTodo) {
+	plannedTweets := []plannedTweet{}
+	skippedTodos := []skippedTodo{}
+	for _, projectTodos := range publicTodos {
+		for _, todo := range projectTodos.Todos {
+			if window.isInFuture(todo.CreatedAt) {
+				logger.Warn("Todo was completed in the future, WIP's clock may be off", "todo_id", todo.ID, "completed_at", todo.CreatedAt, "policy", cfg.FutureCompletionPolicy)
+				switch cfg.FutureCompletionPolicy {
+				case FUTURE_COMPLETION_POLICY_WAIT:
+					continue
+				case FUTURE_COMPLETION_POLICY_SKIP:
+					skippedTodos = append(skippedTodos, skippedTodo{ProjectID: projectTodos.Project.ID, TodoID: todo.ID, Reason: "future_completion"})
+					continue
+				}
+				// Clamped todos are planned as if they were completed now, whatever the window
+			} else {
+				// If this todo wasn't completed within this run's window, don't bother tweeting about it because a previous (or the next) run covers it (we run every hour to catch todos from the previous hour)
+				if window.isTooFresh(todo.CreatedAt) {
+					logger.Info("Skipping todo", "todo_id", todo.ID, "reason", "too_fresh")
+					skippedTodos = append(skippedTodos, skippedTodo{ProjectID: projectTodos.Project.ID, TodoID: todo.ID, Reason: "too_fresh"})
+					continue
+				}
+				if !window.contains(todo.CreatedAt) {
+					continue
+				}
+			}
+
+			// Expand shortcodes up front so everything after (skipping, hashtags, length counting) sees the real emoji
+			if cfg.ExpandEmojiShortcodes {
+				todo.Body = lib_text.ExpandShortcodes(todo.Body)
+			}
+
+			if reason := skipReason(todo, cfg); reason != "" {
+				logger.Info("Skipping todo", "todo_id", todo.ID, "reason", reason)
+				skippedTodos = append(skippedTodos, skippedTodo{ProjectID: projectTodos.Project.ID, TodoID: todo.ID, Reason: reason})
+				continue
+			}
+			if kind := lowContentKind(todo); kind != "" {
+				logger.Info("Todo has little content", "todo_id", todo.ID, "kind", kind, "policy", cfg.LowContentPolicy)
+				switch cfg.LowContentPolicy {
+				case LOW_CONTENT_POLICY_SKIP:
+					skippedTodos = append(skippedTodos, skippedTodo{ProjectID: projectTodos.Project.ID, TodoID: todo.ID, Reason: kind})
+					continue
+				case LOW_CONTENT_POLICY_AUGMENT:
+					todo.Body = augmentLowContentBody(projectTodos.Project, todo.Body)
+				}
+			}
+			plannedTweets = append(plannedTweets, planTweet(projectTodos.Project, todo, cfg, logger))
+		}
+	}
+	return plannedTweets, skippedTodos
+}
+
+// Returns why a todo shouldn't be tweeted, or an empty string if it should be
+func skipReason(todo lib_wip.Todo, cfg Config) string {
+	// A todo that's just a 🎉 makes for a pretty empty tweet, unless there's an attachment to go with it
+	if cfg.SkipEmojiOnlyTodos && len(todo.Attachments) == 0 && lib_text.IsEmojiOnly(todo.Body) {
+		return "emoji_only"
+	}
+	return ""
+}
+
+func planTweet(project lib_wip.Project, todo lib_wip.Todo, cfg Config, logger *slog.Logger) plannedTweet {
+	planned := plannedTweet{Project: project, ReplySettings: cfg.defaultReplySettings}
+	if cfg.NormalizeWhitespace {
+		todo.Body = lib_text.NormalizeWhitespace(todo.Body)
+	}
+	if cfg.TrimTrailingPunctuation {
+		todo.Body = lib_text.TrimTrailingPunctuation(todo.Body)
+	}
+	if body, replySettingsMarker, ok := extractReplySettingsMarker(todo.Body); ok {
+		todo.Body = body
+		replySettings, err := parseReplySettings(replySettingsMarker)
+		if err != nil {
+			logger.Warn("Ignoring invalid reply settings marker", "todo_id", todo.ID, "error", err)
+		} else {
+			planned.ReplySettings = replySettings
+		}
+	}
+	if body, pollMarker, ok := extractPollMarker(todo.Body); ok {
+		todo.Body = body
+		pollOptions, err := parsePollOptions(pollMarker)
+		if err != nil {
+			logger.Warn("Ignoring invalid poll marker", "todo_id", todo.ID, "error", err)
+		} else {
+			planned.PollOptions = pollOptions
+		}
+	}
+	// Code reads badly as tweet text, so it goes in images instead, each one taking up an attachment slot
+	maxAttachments := cfg.MaxAttachmentsPerTodo
+	if cfg.CodeToImage {
+		todo.Body, planned.CodeBlocks = extractCodeBlocks(todo.Body, DEFAULT_MAX_ATTACHMENTS_PER_TODO)
+		maxAttachments = max(maxAttachments-len(planned.CodeBlocks), 0)
+	}
+	if cfg.MaxEmoji > 0 {
+		todo.Body = limitEmojiOutsideCode(todo.Body, cfg.MaxEmoji)
+	}
+	planned.Todo = todo
+	if cfg.changelogURLTemplate != nil {
+		changelogURL, err := renderChangelogURL(cfg.changelogURLTemplate, project, todo)
+		if err != nil {
+			logger.Warn("Could not render the changelog link, tweeting without it", "todo_id", todo.ID, "error", err)
+		} else {
+			planned.ChangelogURL = changelogURL
+		}
+	}
+	maxTextLength := planned.maxTextLength()
+
+	// Raw bodies are tweeted as they are, without the checkmark, hashtags or any other decoration
+	decorate := func(body string, hashtags []string) string {
+		return buildTweetMessage(body, hashtags, cfg.HashtagPosition)
+	}
+	if cfg.RawBody {
+		decorate = func(body string, hashtags []string) string { return body }
+	} else {
+		planned.Hashtags = selectHashtags(todo.Body, cfg.keywordHashtags, cfg.ReplaceDefaultHashtag)
+	}
+	// Only the first few lines of long multi-line bodies make it into the tweet
+	tweetBody := limitLines(todo.Body, cfg.MaxBodyLines)
+
+	// If the todo is too long for a tweet, tweet the first sentence and attach the full body as an image instead, as long
+	// as the code images left room for it
+	if cfg.TextToImageOverflow && len(planned.CodeBlocks) < DEFAULT_MAX_ATTACHMENTS_PER_TODO && tweetLength(decorate(cfg.CompletionVerb+tweetBody, planned.Hashtags)) > maxTextLength {
+		planned.TextImageBody = todo.Body
+		tweetBody = firstSentence(tweetBody)
+		// The body image takes up one of the attachment slots
+		maxAttachments = max(maxAttachments-1, 0)
+	}
+	// The verb reads as the start of the body, so it's counted and packed along with it
+	tweetBody = cfg.CompletionVerb + tweetBody
+	planned.Body = tweetBody
+	planned.Text = fitTweetMessageWithin("", tweetBody, planned.Hashtags, cfg.HashtagPosition, maxTextLength)
+	if cfg.RawBody {
+		planned.Text = truncateToTweetLength(tweetBody, maxTextLength)
+	}
+
+	// The project's domain is a nice to have, so it's only added when the whole body still fits
+	if cfg.ShowProjectDomain && !cfg.RawBody {
+		if domain := projectDomain(project.WebsiteURL); domain != "" {
+			bodyWithDomain := tweetBody + " — " + domain
+			textWithDomain := buildTweetMessage(bodyWithDomain, planned.Hashtags, cfg.HashtagPosition)
+			// Twitter links the domain, and links always count as TCO_URL_LENGTH
+			if tweetLength(textWithDomain)-tweetLength(domain)+TCO_URL_LENGTH <= maxTextLength {
+				planned.Body = bodyWithDomain
+				planned.Text = textWithDomain
+			}
+		}
+	}
+
+	// Cap the attachments before uploading anything so we don't waste uploads on media that won't be used
+	planned.Attachments = todo.Attachments
+	if len(planned.Attachments) > maxAttachments {
+		logger.Info("Skipping extra attachments", "todo_id", todo.ID, "num_attachments", len(planned.Attachments), "max_attachments", maxAttachments)
+		planned.Attachments = planned.Attachments[:maxAttachments]
+	}
+
+	// Tweets can't have both a poll and media, and the media is the part that can't be left out of the body
+	if len(planned.PollOptions) > 0 && (len(planned.Attachments) > 0 || planned.TextImageBody != "" || len(planned.CodeBlocks) > 0) {
+		logger.Warn("Ignoring poll marker because the tweet has media", "todo_id", todo.ID)
+		planned.PollOptions = nil
+	}
+	return planned
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const (
+	MIN_POLL_OPTIONS      = 2
+	MAX_POLL_OPTIONS      = 4
+	MAX_POLL_OPTION_RUNES = 25
+	// Twitter allows polls to run between 5 minutes and 7 days
+	MIN_POLL_DURATION_MINUTES     = 5
+	MAX_POLL_DURATION_MINUTES     = 7 * 24 * 60
+	DEFAULT_POLL_DURATION_MINUTES = 24 * 60
+)
+
+// Turns a todo into a poll, e.g. "Which logo should I go with? !poll: Blue | Green | Red". Runs to the end of the line.
+var pollMarkerPattern = regexp.MustCompile(`(?i)\s*!poll:([^\n]*)`)
+
+// Removes the poll marker from the body, returning the cleaned body and the marker's value if there was one
+func extractPollMarker(body string) (string, string, bool) {
+	match := pollMarkerPattern.FindStringSubmatch(body)
+	if match == nil {
+		return body, "", false
+	}
+	return strings.TrimSpace(pollMarkerPattern.ReplaceAllString(body, "")), match[1], true
+}
+
+func parsePollOptions(value string) ([]string, error) {
+	options := []string{}
+	for _, option := range strings.Split(value, "|") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if len([]rune(option)) > MAX_POLL_OPTION_RUNES {
+			return nil, fmt.Errorf("poll option %q is longer than %d characters", option, MAX_POLL_OPTION_RUNES)
+		}
+		options = append(options, option)
+	}
+	if len(options) < MIN_POLL_OPTIONS || len(options) > MAX_POLL_OPTIONS {
+		return nil, fmt.Errorf("polls need between %d and %d options, got %d", MIN_POLL_OPTIONS, MAX_POLL_OPTIONS, len(options))
+	}
+	return options, nil
+}
+
+// go-twitter's request validation dereferences Reply whenever there's a poll, so a poll that isn't a reply would panic
+// in CreateTweet. Polls are sent straight to the endpoint instead.
+func createPollTweet(ctx context.Context, client *twitter2.Client, request twitter2.CreateTweetRequest) (*twitter2.CreateTweetResponse, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the tweet: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Host+"/2/tweets", bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", CONTENT_TYPE_APPLICATION_JSON)
+
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		// Like the client's own errors, so a rejected poll can be told apart from one that may have been posted
+		return nil, fmt.Errorf("%w: %s", &twitter2.HTTPError{Status: resp.Status, StatusCode: resp.StatusCode, URL: req.URL.String()}, respBytes)
+	}
+	createTweetResponse := &twitter2.CreateTweetResponse{}
+	if err := json.Unmarshal(respBytes, createTweetResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the response: %w", err)
+	}
+	return createTweetResponse, nil
+}
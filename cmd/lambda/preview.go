@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	lib_render "github.com/bakatz/wip-to-twitter-bridge/lib/render"
+)
+
+const DEFAULT_PREVIEW_PORT = "8080"
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Upcoming tweets</title>
+<style>
+body { font-family: sans-serif; max-width: 640px; margin: 2em auto; color: #14171a; }
+.tweet { border: 1px solid #e1e8ed; border-radius: 12px; padding: 1em; margin-bottom: 1em; }
+.text { white-space: pre-wrap; }
+.meta { color: #657786; font-size: 0.85em; margin-top: 0.5em; }
+.media img { max-width: 48%; max-height: 200px; margin: 0.5em 0.5em 0 0; border-radius: 8px; }
+</style>
+</head>
+<body>
+<h1>{{len .Tweets}} upcoming tweet(s)</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+{{range .Tweets}}
+<div class="tweet">
+<div class="text">{{.Text}}</div>
+<div class="media">{{range .ImageURLs}}<img src="{{.}}">{{end}}</div>
+<div class="meta">{{.Project}} · {{.Length}}/{{$.MaxLength}} characters</div>
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+type previewTweet struct {
+	Project string
+	Text    string
+	Length  int
+	// The images rendered here are data: URLs marked safe with template.URL. Attachment URLs come from the WIP API, so
+	// they're plain strings that html/template checks itself, only letting http and https through.
+	ImageURLs []any
+}
+
+type previewPage struct {
+	Tweets    []previewTweet
+	MaxLength int
+	Error     string
+}
+
+// Serves an HTML page showing what the next run would tweet, without posting anything
+func startPreviewServer(logger *slog.Logger) error {
+	port := os.Getenv("PREVIEW_PORT")
+	if port == "" {
+		port = DEFAULT_PREVIEW_PORT
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		page := buildPreviewPage(logger)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := previewTemplate.Execute(w, page); err != nil {
+			logger.Error("Error rendering the preview page", "error", err)
+		}
+	})
+
+	// The page shows todos before they're public, so it's only served to this machine
+	logger.Info("Serving tweet previews", "url", "http://localhost:"+port)
+	return http.ListenAndServe("127.0.0.1:"+port, nil)
+}
+
+func buildPreviewPage(logger *slog.Logger) previewPage {
+	page := previewPage{MaxLength: MAX_TWEET_LENGTH}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		page.Error = err.Error()
+		return page
+	}
+
+	if cfg.WIPAPIKey == "" {
+		page.Error = "WIP_API_KEY is missing, set it and restart the preview server"
+		return page
+	}
+
+	wipClient, err := newWIPClient(cfg.WIPAPIKey, cfg, logger)
+	if err != nil {
+		page.Error = "Could not load the WIP cache file: " + err.Error()
+		return page
+	}
+
+	publicTodos, err := fetchPublicTodos(wipClient)
+	if err != nil {
+		page.Error = "Error getting completed todos from WIP: " + err.Error()
+		return page
+	}
+	plannedTweets, _ := planTweets(publicTodos, cfg, newLookbackWindow(time.Now().UTC(), cfg.WindowGraceSeconds, cfg.MinAgeBeforeTweetMinutes), logger)
+
+	for _, plannedTweet := range plannedTweets {
+		tweet := previewTweet{
+			Project: plannedTweet.Project.Name,
+			Text:    plannedTweet.Text,
+			Length:  tweetLength(plannedTweet.Text),
+		}
+		if plannedTweet.TextImageBody != "" {
+			textImage, err := lib_render.RenderTextCard(plannedTweet.TextImageBody, lib_render.DefaultTextCardOptions())
+			if err != nil {
+				logger.Warn("Error rendering the todo body as an image", "todo_id", plannedTweet.Todo.ID, "error", err)
+			} else {
+				tweet.ImageURLs = append(tweet.ImageURLs, template.URL("data:image/png;base64,"+base64.StdEncoding.EncodeToString(textImage)))
+			}
+		}
+		for _, codeBlock := range plannedTweet.CodeBlocks {
+			codeImage, err := lib_render.RenderCode(codeBlock.Code, codeBlock.Language, lib_render.DefaultCodeImageOptions())
+			if err != nil {
+				logger.Warn("Error rendering a code block as an image", "todo_id", plannedTweet.Todo.ID, "error", err)
+			} else {
+				tweet.ImageURLs = append(tweet.ImageURLs, template.URL("data:image/png;base64,"+base64.StdEncoding.EncodeToString(codeImage)))
+			}
+		}
+		for _, attachment := range plannedTweet.Attachments {
+			tweet.ImageURLs = append(tweet.ImageURLs, attachment.URL)
+		}
+		page.Tweets = append(page.Tweets, tweet)
+	}
+	return page
+}
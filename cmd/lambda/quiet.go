@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// Where todos completed during quiet periods wait for the next active run
+const DEFERRED_TODOS_KEY = "deferred_todos"
+
+// How long a todo whose attachment is still processing waits before it's tried again, for REQUEUE_PROCESSING_ATTACHMENTS
+const DEFAULT_REQUEUE_DELAY_MINUTES = 10
+
+// quietHours is a daily period in the configured timezone, in minutes since midnight. It wraps past midnight when End is
+// before Start, e.g. 22:00-07:00.
+type quietHours struct {
+	Start int
+	End   int
+}
+
+// Parses a period like "22:00-07:00"
+func parseQuietHours(value string) (*quietHours, error) {
+	startValue, endValue, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected a period like 22:00-07:00, got %q", value)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startValue))
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time %q", startValue)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endValue))
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time %q", endValue)
+	}
+	return &quietHours{Start: start.Hour()*60 + start.Minute(), End: end.Hour()*60 + end.Minute()}, nil
+}
+
+func (q quietHours) contains(minuteOfDay int) bool {
+	if q.Start <= q.End {
+		return minuteOfDay >= q.Start && minuteOfDay < q.End
+	}
+	return minuteOfDay >= q.Start || minuteOfDay < q.End
+}
+
+// Works on the wall clock time in the configured timezone, so quiet hours follow DST changes
+func isQuietTime(cfg Config, now time.Time) bool {
+	localNow := now.In(cfg.location)
+	if cfg.SkipWeekends && (localNow.Weekday() == time.Saturday || localNow.Weekday() == time.Sunday) {
+		return true
+	}
+	return cfg.quietHours != nil && cfg.quietHours.contains(localNow.Hour()*60+localNow.Minute())
+}
+
+type deferredTodo struct {
+	ProjectID string `json:"project_id"`
+	TodoID    string `json:"todo_id"`
+	// When the todo was completed, to tell whether the fetched todos reach back far enough that it should be among them
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+	// Set when the todo has to wait until then, rather than going out with the next run that catches up
+	DeferUntil time.Time `json:"defer_until,omitempty"`
+}
+
+func (d deferredTodo) isDue(now time.Time) bool {
+	return !d.DeferUntil.After(now)
+}
+
+func newDeferredTodo(plannedTweet plannedTweet) deferredTodo {
+	return deferredTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, CompletedAt: plannedTweet.Todo.CreatedAt}
+}
+
+func parseDeferredTodos(value string) ([]deferredTodo, error) {
+	deferredTodos := []deferredTodo{}
+	if value == "" {
+		return deferredTodos, nil
+	}
+	if err := json.Unmarshal([]byte(value), &deferredTodos); err != nil {
+		return nil, fmt.Errorf("could not unmarshal the deferred todos: %w", err)
+	}
+	return deferredTodos, nil
+}
+
+func loadDeferredTodos(ctx context.Context, stateStore lib_state.Store) ([]deferredTodo, error) {
+	value, err := stateStore.GetValue(ctx, DEFERRED_TODOS_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeferredTodos(value)
+}
+
+// Changes the deferred todos in the state store without losing any that another run deferred at the same time
+func updateDeferredTodos(ctx context.Context, stateStore lib_state.Store, update func(deferredTodos []deferredTodo) []deferredTodo) error {
+	return stateStore.UpdateValue(ctx, DEFERRED_TODOS_KEY, func(value string) (string, error) {
+		deferredTodos, err := parseDeferredTodos(value)
+		if err != nil {
+			return "", err
+		}
+		deferredTodos = update(deferredTodos)
+		if len(deferredTodos) == 0 {
+			return "", nil
+		}
+		newValue, err := json.Marshal(deferredTodos)
+		return string(newValue), err
+	})
+}
+
+// Adds the planned tweets to the deferred todos, returning how many were newly deferred. A zero deferUntil leaves them
+// to the next run that catches up.
+func deferPlannedTweets(ctx context.Context, stateStore lib_state.Store, plannedTweets []plannedTweet, deferUntil time.Time) (int, error) {
+	numDeferred := 0
+	err := updateDeferredTodos(ctx, stateStore, func(deferredTodos []deferredTodo) []deferredTodo {
+		alreadyDeferred := map[string]bool{}
+		for _, deferred := range deferredTodos {
+			alreadyDeferred[deferred.TodoID] = true
+		}
+		numDeferred = 0
+		for _, plannedTweet := range plannedTweets {
+			if alreadyDeferred[plannedTweet.Todo.ID] {
+				continue
+			}
+			deferred := newDeferredTodo(plannedTweet)
+			deferred.DeferUntil = deferUntil
+			deferredTodos = append(deferredTodos, deferred)
+			numDeferred++
+		}
+		return deferredTodos
+	})
+	return numDeferred, err
+}
+
+// Defers the todos of a planned tweet that couldn't go out yet until deferUntil, even if they were already deferred
+func requeuePlannedTweet(ctx context.Context, stateStore lib_state.Store, plannedTweet plannedTweet, deferUntil time.Time) error {
+	requeuedIDs := map[string]bool{}
+	requeued := []deferredTodo{}
+	for _, todo := range append(plannedTweet.todos(), plannedTweet.MergedTodos...) {
+		requeuedIDs[todo.ID] = true
+		requeued = append(requeued, deferredTodo{ProjectID: plannedTweet.Project.ID, TodoID: todo.ID, CompletedAt: todo.CreatedAt, DeferUntil: deferUntil})
+	}
+	return updateDeferredTodos(ctx, stateStore, func(deferredTodos []deferredTodo) []deferredTodo {
+		remaining := []deferredTodo{}
+		for _, deferred := range deferredTodos {
+			if !requeuedIDs[deferred.TodoID] {
+				remaining = append(remaining, deferred)
+			}
+		}
+		return append(remaining, requeued...)
+	})
+}
+
+// Splits the deferred todos into those that are due and the IDs of those still waiting until the time they were
+// deferred until
+func splitDueDeferredTodos(deferredTodos []deferredTodo, now time.Time) ([]deferredTodo, map[string]bool) {
+	due := []deferredTodo{}
+	waitingIDs := map[string]bool{}
+	for _, deferred := range deferredTodos {
+		if deferred.isDue(now) {
+			due = append(due, deferred)
+		} else {
+			waitingIDs[deferred.TodoID] = true
+		}
+	}
+	return due, waitingIDs
+}
+
+// The deferred todos the run had a chance to tweet, or found were gone: those from projects it fetched, e.g. not left
+// out by PROJECT_FILTER, that it could find and that aren't routed to an account another run is busy with
+func handledDeferredTodos(publicTodos []projectWithTodos, deferredTodos []deferredTodo, router *twitterRouter, busyAccounts map[string]bool) []deferredTodo {
+	handled := []deferredTodo{}
+	for _, deferred := range deferredTodos {
+		if busyAccounts[router.accountFor(deferred.ProjectID).UserID] {
+			continue
+		}
+		if isDeferredTodoFetched(publicTodos, deferred) || isDeferredTodoGone(publicTodos, deferred) {
+			handled = append(handled, deferred)
+		}
+	}
+	return handled
+}
+
+func isDeferredTodoFetched(publicTodos []projectWithTodos, deferred deferredTodo) bool {
+	for _, projectTodos := range publicTodos {
+		if projectTodos.Project.ID != deferred.ProjectID {
+			continue
+		}
+		for _, todo := range projectTodos.Todos {
+			if todo.ID == deferred.TodoID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the deferred or queued todo was deleted or made private: its project was fetched and the fetched todos
+// reach back to when it was completed, but it isn't among them. Todos from projects that weren't fetched, e.g. because
+// of PROJECT_FILTER, or older than the todos fetched so far may well still be there, so they're kept.
+func isDeferredTodoGone(publicTodos []projectWithTodos, deferred deferredTodo) bool {
+	for _, projectTodos := range publicTodos {
+		if projectTodos.Project.ID != deferred.ProjectID {
+			continue
+		}
+		reachesBack := projectTodos.NextPageAfter == ""
+		for _, todo := range projectTodos.Todos {
+			if todo.ID == deferred.TodoID {
+				return false
+			}
+			if !deferred.CompletedAt.IsZero() && !todo.CreatedAt.After(deferred.CompletedAt) {
+				reachesBack = true
+			}
+		}
+		return reachesBack
+	}
+	return false
+}
+
+// How many more pages of a project's todos a run fetches looking for its deferred todos
+const MAX_DEFERRED_TODO_PAGES = 10
+
+// A busy weekend or a long quiet period can push deferred todos off the first page of their project's todos, so this
+// fetches older pages of the fetched projects until their deferred todos are found or known to be gone. Returns the
+// projects with the older todos added, leaving publicTodos as it was.
+func fetchOlderDeferredTodos(wipClient *lib_wip.Client, publicTodos []projectWithTodos, deferredTodos []deferredTodo) ([]projectWithTodos, error) {
+	withOlderTodos := []projectWithTodos{}
+	for _, projectTodos := range publicTodos {
+		projectTodos.Todos = append([]lib_wip.Todo{}, projectTodos.Todos...)
+		for page := 0; page < MAX_DEFERRED_TODO_PAGES && isMissingDeferredTodos(projectTodos, deferredTodos); page++ {
+			todos, err := wipClient.GetProjectTodos(projectTodos.Project.ID, nil, &projectTodos.NextPageAfter)
+			if err != nil {
+				return nil, fmt.Errorf("could not get older project todos: %w", err)
+			}
+			addPublicTodos(&projectTodos, todos)
+		}
+		withOlderTodos = append(withOlderTodos, projectTodos)
+	}
+	return withOlderTodos, nil
+}
+
+// Reports whether some of the project's deferred todos may be on pages that haven't been fetched yet
+func isMissingDeferredTodos(projectTodos projectWithTodos, deferredTodos []deferredTodo) bool {
+	if projectTodos.NextPageAfter == "" {
+		return false
+	}
+	for _, deferred := range deferredTodos {
+		if deferred.ProjectID != projectTodos.Project.ID {
+			continue
+		}
+		projectOnly := []projectWithTodos{projectTodos}
+		if !isDeferredTodoFetched(projectOnly, deferred) && !isDeferredTodoGone(projectOnly, deferred) {
+			return true
+		}
+	}
+	return false
+}
+
+// The deferred todos that were deleted or made private in the meantime, which are dropped rather than tweeted
+func goneDeferredTodos(publicTodos []projectWithTodos, deferredTodos []deferredTodo, logger *slog.Logger) []skippedTodo {
+	skippedTodos := []skippedTodo{}
+	for _, deferred := range deferredTodos {
+		if isDeferredTodoGone(publicTodos, deferred) {
+			logger.Info("Skipping todo", "todo_id", deferred.TodoID, "reason", "gone_from_wip")
+			skippedTodos = append(skippedTodos, skippedTodo{ProjectID: deferred.ProjectID, TodoID: deferred.TodoID, Reason: "gone_from_wip"})
+		}
+	}
+	return skippedTodos
+}
+
+// Takes the handled todos out of the deferred ones, keeping those deferred or requeued since they were loaded
+func forgetDeferredTodos(ctx context.Context, stateStore lib_state.Store, handled []deferredTodo) error {
+	handledDeferUntil := map[string]time.Time{}
+	for _, deferred := range handled {
+		handledDeferUntil[deferred.TodoID] = deferred.DeferUntil
+	}
+	return updateDeferredTodos(ctx, stateStore, func(deferredTodos []deferredTodo) []deferredTodo {
+		remaining := []deferredTodo{}
+		for _, deferred := range deferredTodos {
+			deferUntil, ok := handledDeferUntil[deferred.TodoID]
+			if !ok || !deferUntil.Equal(deferred.DeferUntil) {
+				remaining = append(remaining, deferred)
+			}
+		}
+		return remaining
+	})
+}
+
+// Picks the deferred todos out of the fetched ones so they can be planned like any other
+func findDeferredTodos(publicTodos []projectWithTodos, deferredTodos []deferredTodo) []projectWithTodos {
+	deferredIDs := map[string]bool{}
+	for _, deferred := range deferredTodos {
+		deferredIDs[deferred.TodoID] = true
+	}
+
+	found := []projectWithTodos{}
+	for _, projectTodos := range publicTodos {
+		projectDeferred := projectWithTodos{Project: projectTodos.Project}
+		for _, todo := range projectTodos.Todos {
+			if deferredIDs[todo.ID] {
+				projectDeferred.Todos = append(projectDeferred.Todos, todo)
+			}
+		}
+		if len(projectDeferred.Todos) > 0 {
+			found = append(found, projectDeferred)
+		}
+	}
+	return found
+}
+
+// How many runs there have been, for TWEET_EVERY_N_RUNS
+const RUN_COUNT_KEY = "run_count"
+
+// Counts this run and reports whether it's one of the runs that tweets, i.e. every nth one. The runs in between defer
+// their todos just like quiet periods do.
+func countPostingRun(ctx context.Context, stateStore lib_state.Store, everyNRuns int) (bool, error) {
+	runCount := 0
+	err := stateStore.UpdateValue(ctx, RUN_COUNT_KEY, func(value string) (string, error) {
+		runCount = 0
+		if value != "" {
+			var err error
+			runCount, err = strconv.Atoi(value)
+			if err != nil {
+				return "", fmt.Errorf("could not parse the run count %q: %w", value, err)
+			}
+		}
+		runCount++
+		return strconv.Itoa(runCount), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return runCount%everyNRuns == 0, nil
+}
+
+// Deferred todos were completed in earlier windows, so they're planned without one
+func allTime() lookbackWindow {
+	end := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	return lookbackWindow{End: end, FreshUntil: end}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+func TestDeferredTodosOffTheFirstPage(t *testing.T) {
+	saturday := time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC)
+	firstPage := projectWithTodos{
+		Project: lib_wip.Project{ID: "p1"},
+		Todos: []lib_wip.Todo{
+			{ID: "t3", CreatedAt: saturday.Add(48 * time.Hour)},
+			{ID: "t2", CreatedAt: saturday.Add(47 * time.Hour)},
+		},
+		NextPageAfter: "t2",
+	}
+	weekendTodo := deferredTodo{ProjectID: "p1", TodoID: "t1", CompletedAt: saturday}
+	deletedTodo := deferredTodo{ProjectID: "p1", TodoID: "t0", CompletedAt: saturday.Add(47*time.Hour + 30*time.Minute)}
+	unfetchedProjectTodo := deferredTodo{ProjectID: "p2", TodoID: "t9", CompletedAt: saturday}
+	deferredTodos := []deferredTodo{weekendTodo, deletedTodo, unfetchedProjectTodo}
+	publicTodos := []projectWithTodos{firstPage}
+
+	if isDeferredTodoGone(publicTodos, weekendTodo) {
+		t.Errorf("a todo older than the first page counts as gone")
+	}
+	if !isDeferredTodoGone(publicTodos, deletedTodo) {
+		t.Errorf("a todo missing from the pages that reach back to it doesn't count as gone")
+	}
+	if isDeferredTodoGone(publicTodos, unfetchedProjectTodo) {
+		t.Errorf("a todo from a project that wasn't fetched counts as gone")
+	}
+	if !isMissingDeferredTodos(firstPage, deferredTodos) {
+		t.Errorf("the first page isn't enough to find a todo older than it, but no more pages would be fetched")
+	}
+
+	// The next page is the last one and has the weekend todo on it
+	secondPage := firstPage
+	secondPage.Todos = append(append([]lib_wip.Todo{}, firstPage.Todos...), lib_wip.Todo{ID: "t1", CreatedAt: saturday})
+	secondPage.NextPageAfter = ""
+	if isMissingDeferredTodos(secondPage, deferredTodos) {
+		t.Errorf("more pages would be fetched after they've all been fetched")
+	}
+	if !isDeferredTodoFetched([]projectWithTodos{secondPage}, weekendTodo) {
+		t.Errorf("the weekend todo wasn't found on the second page")
+	}
+
+	skippedTodos := goneDeferredTodos([]projectWithTodos{secondPage}, deferredTodos, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if len(skippedTodos) != 1 || skippedTodos[0].TodoID != "t0" || skippedTodos[0].Reason != "gone_from_wip" {
+		t.Errorf("got skipped todos %+v, want only t0 as gone_from_wip", skippedTodos)
+	}
+}
+
+func TestDeferredTodoWithoutCompletionTime(t *testing.T) {
+	// Todos deferred before their completion time was kept are only gone once every page has been fetched
+	deferred := deferredTodo{ProjectID: "p1", TodoID: "t1"}
+	projectTodos := projectWithTodos{Project: lib_wip.Project{ID: "p1"}, Todos: []lib_wip.Todo{{ID: "t2"}}, NextPageAfter: "t2"}
+	if isDeferredTodoGone([]projectWithTodos{projectTodos}, deferred) {
+		t.Errorf("counts as gone with more pages to fetch")
+	}
+	projectTodos.NextPageAfter = ""
+	if !isDeferredTodoGone([]projectWithTodos{projectTodos}, deferred) {
+		t.Errorf("doesn't count as gone with every page fetched")
+	}
+}
+
+func TestRequeuedTodoWaitsAndOutlivesTheRunThatLoadedIt(t *testing.T) {
+	ctx := context.Background()
+	stateStore := lib_state.NewMemoryStore()
+	now := time.Date(2026, 10, 12, 12, 0, 0, 0, time.UTC)
+	planned := plannedTweet{Project: lib_wip.Project{ID: "p1"}, Todo: lib_wip.Todo{ID: "t1", CreatedAt: now}}
+	if _, err := deferPlannedTweets(ctx, stateStore, []plannedTweet{planned}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run catching up loads it, but its attachment is still processing so it's requeued
+	loaded, err := loadDeferredTodos(ctx, stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := requeuePlannedTweet(ctx, stateStore, planned, now.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := forgetDeferredTodos(ctx, stateStore, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	deferredTodos, err := loadDeferredTodos(ctx, stateStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deferredTodos) != 1 || !deferredTodos[0].DeferUntil.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("got deferred todos %+v, want t1 requeued for 10 minutes", deferredTodos)
+	}
+	if due, waitingIDs := splitDueDeferredTodos(deferredTodos, now.Add(5*time.Minute)); len(due) != 0 || !waitingIDs["t1"] {
+		t.Errorf("the requeued todo is due before the time it was requeued until")
+	}
+	if due, waitingIDs := splitDueDeferredTodos(deferredTodos, now.Add(10*time.Minute)); len(due) != 1 || len(waitingIDs) != 0 {
+		t.Errorf("the requeued todo isn't due once the time it was requeued until has come")
+	}
+}
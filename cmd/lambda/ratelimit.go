@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_REMAINING_HEADER = "x-rate-limit-remaining"
+	RATE_LIMIT_RESET_HEADER     = "x-rate-limit-reset"
+	CREATE_TWEET_PATH           = "/2/tweets"
+	// Leaves time to send the tweet after waiting out a rate limit, rather than waiting right up to the deadline
+	RATE_LIMIT_DEADLINE_MARGIN = 10 * time.Second
+)
+
+type rateLimit struct {
+	Remaining int
+	Reset     time.Time
+}
+
+// Remembers the latest rate limit Twitter reported for each endpoint during this run
+type rateLimitTracker struct {
+	mu     sync.Mutex
+	limits map[string]rateLimit
+}
+
+func newRateLimitTracker() *rateLimitTracker {
+	return &rateLimitTracker{limits: map[string]rateLimit{}}
+}
+
+func (t *rateLimitTracker) record(path string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get(RATE_LIMIT_REMAINING_HEADER))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get(RATE_LIMIT_RESET_HEADER), 10, 64)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits[path] = rateLimit{Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
+
+func (t *rateLimitTracker) latest(path string) (rateLimit, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit, ok := t.limits[path]
+	return limit, ok
+}
+
+// Records the rate limit headers of every response, whichever call made the request
+type rateLimitTransport struct {
+	tracker *rateLimitTracker
+	base    http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.tracker.record(req.URL.Path, resp.Header)
+	}
+	return resp, err
+}
+
+// Sleeps until the endpoint's rate limit window resets when there are no more than minRemaining calls left in it, so the
+// next call doesn't fail with a 429. It never sleeps past the run's deadline: if the window resets later than that, the
+// call goes ahead after waiting as long as we can and is handled like any other failure.
+func (a *twitterAccount) waitForRateLimit(ctx context.Context, path string, minRemaining int, logger *slog.Logger) {
+	limit, ok := a.rateLimits.latest(path)
+	if !ok || limit.Remaining > minRemaining {
+		return
+	}
+	wait := time.Until(limit.Reset)
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = min(wait, time.Until(deadline)-RATE_LIMIT_DEADLINE_MARGIN)
+	}
+	if wait <= 0 {
+		return
+	}
+
+	logger.Warn("Nearly out of Twitter API calls, waiting for the rate limit to reset", "path", path, "remaining", limit.Remaining, "reset", limit.Reset, "wait", wait, "twitter_user_id", a.UserID)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
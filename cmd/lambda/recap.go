@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const (
+	// The todos tweeted since the last recap, stored as one JSON list like the deferred todos
+	WEEKLY_RECAP_TODOS_KEY = "weekly_recap_todos"
+	// The date the last recap went out, so a retried or extra run in the same hour doesn't send it twice
+	WEEKLY_RECAP_SENT_ON_KEY  = "weekly_recap_sent_on"
+	DEFAULT_WEEKLY_RECAP_DAY  = "sunday"
+	DEFAULT_WEEKLY_RECAP_HOUR = 18
+	WEEKLY_RECAP_QUIET_SKIP   = "skip"
+	WEEKLY_RECAP_QUIET_POST   = "post"
+	WEEKLY_RECAP_PERIOD       = 7 * 24 * time.Hour
+)
+
+// recapTodo is a tweeted todo, kept until the weekly recap that mentions it
+type recapTodo struct {
+	ProjectID   string    `json:"project_id"`
+	ProjectName string    `json:"project_name"`
+	Body        string    `json:"body"`
+	TweetedAt   time.Time `json:"tweeted_at"`
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), strings.TrimSpace(name)) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("%q isn't a day of the week", name)
+}
+
+func parseRecapTodos(value string) ([]recapTodo, error) {
+	recapTodos := []recapTodo{}
+	if value == "" {
+		return recapTodos, nil
+	}
+	if err := json.Unmarshal([]byte(value), &recapTodos); err != nil {
+		return nil, fmt.Errorf("could not unmarshal the weekly recap todos: %w", err)
+	}
+	return recapTodos, nil
+}
+
+func loadRecapTodos(ctx context.Context, stateStore lib_state.Store) ([]recapTodo, error) {
+	value, err := stateStore.GetValue(ctx, WEEKLY_RECAP_TODOS_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecapTodos(value)
+}
+
+// Changes the recap todos in the state store without losing any that another run recorded at the same time
+func updateRecapTodos(ctx context.Context, stateStore lib_state.Store, update func(recapTodos []recapTodo) []recapTodo) error {
+	return stateStore.UpdateValue(ctx, WEEKLY_RECAP_TODOS_KEY, func(value string) (string, error) {
+		recapTodos, err := parseRecapTodos(value)
+		if err != nil {
+			return "", err
+		}
+		recapTodos = update(recapTodos)
+		if len(recapTodos) == 0 {
+			return "", nil
+		}
+		newValue, err := json.Marshal(recapTodos)
+		return string(newValue), err
+	})
+}
+
+// Remembers a tweeted todo for the next recap. Todos from before the last week are dropped on the way, so the list
+// can't grow forever while recaps are failing.
+func recordRecapTodo(ctx context.Context, stateStore lib_state.Store, project lib_wip.Project, todo lib_wip.Todo, now time.Time) error {
+	return updateRecapTodos(ctx, stateStore, func(recapTodos []recapTodo) []recapTodo {
+		return append(recentRecapTodos(recapTodos, now), recapTodo{ProjectID: project.ID, ProjectName: project.Name, Body: todo.Body, TweetedAt: now})
+	})
+}
+
+// Drops the todos the recap mentioned, keeping any tweeted since it was put together for the next one
+func forgetRecapTodos(ctx context.Context, stateStore lib_state.Store, recapped []recapTodo) error {
+	recappedKeys := map[string]bool{}
+	for _, recapTodo := range recapped {
+		recappedKeys[recapTodo.key()] = true
+	}
+	return updateRecapTodos(ctx, stateStore, func(recapTodos []recapTodo) []recapTodo {
+		remaining := []recapTodo{}
+		for _, recapTodo := range recapTodos {
+			if !recappedKeys[recapTodo.key()] {
+				remaining = append(remaining, recapTodo)
+			}
+		}
+		return remaining
+	})
+}
+
+func (t recapTodo) key() string {
+	return t.ProjectID + "#" + t.TweetedAt.Format(time.RFC3339Nano) + "#" + t.Body
+}
+
+func recentRecapTodos(recapTodos []recapTodo, now time.Time) []recapTodo {
+	recent := []recapTodo{}
+	for _, recapTodo := range recapTodos {
+		if now.Sub(recapTodo.TweetedAt) <= WEEKLY_RECAP_PERIOD {
+			recent = append(recent, recapTodo)
+		}
+	}
+	return recent
+}
+
+// The recap goes out on the run that falls in the configured hour of the configured day
+func isWeeklyRecapRun(cfg Config, now time.Time) bool {
+	localNow := now.In(cfg.location)
+	return cfg.WeeklyRecap && localNow.Weekday() == cfg.weeklyRecapDay && localNow.Hour() == cfg.WeeklyRecapHour
+}
+
+// Renders the recap as a thread: a tweet with the totals, then the todos grouped by project in the order they were
+// tweeted, as many to a tweet as fit. Each todo is cut down to its first sentence to keep the thread short.
+func buildWeeklyRecapThread(recapTodos []recapTodo) []string {
+	projectIDs := []string{}
+	projectTodos := map[string][]recapTodo{}
+	for _, recapTodo := range recapTodos {
+		if _, ok := projectTodos[recapTodo.ProjectID]; !ok {
+			projectIDs = append(projectIDs, recapTodo.ProjectID)
+		}
+		projectTodos[recapTodo.ProjectID] = append(projectTodos[recapTodo.ProjectID], recapTodo)
+	}
+
+	thread := []string{fmt.Sprintf("🗓️ This week I shipped %d %s across %d %s %s", len(recapTodos), pluralize(len(recapTodos), "todo", "todos"), len(projectIDs), pluralize(len(projectIDs), "project", "projects"), DEFAULT_HASHTAG)}
+	for _, projectID := range projectIDs {
+		todos := projectTodos[projectID]
+		heading := todos[0].ProjectName + ":"
+		tweet := heading
+		for _, recapTodo := range todos {
+			line := truncateToTweetLength("• "+firstSentence(recapTodo.Body), MAX_TWEET_LENGTH-tweetLength(heading)-1)
+			if tweetLength(tweet)+1+tweetLength(line) > MAX_TWEET_LENGTH {
+				thread = append(thread, tweet)
+				tweet = heading
+			}
+			tweet += "\n" + line
+		}
+		thread = append(thread, tweet)
+	}
+	return thread
+}
+
+// Tweets the recap of the todos tweeted over the last week, returning whether anything was tweeted. Once the first
+// tweet is out the recap counts as sent, so a reply that fails is logged rather than sending the whole recap again.
+func tweetWeeklyRecap(ctx context.Context, stateStore lib_state.Store, account *twitterAccount, cfg Config, now time.Time, logger *slog.Logger) (bool, error) {
+	today := now.In(cfg.location).Format(time.DateOnly)
+	sentOn, err := stateStore.GetValue(ctx, WEEKLY_RECAP_SENT_ON_KEY)
+	if err != nil || sentOn == today {
+		return false, err
+	}
+	recapTodos, err := loadRecapTodos(ctx, stateStore)
+	if err != nil {
+		return false, err
+	}
+	recapTodos = recentRecapTodos(recapTodos, now)
+
+	thread := buildWeeklyRecapThread(recapTodos)
+	if len(recapTodos) == 0 {
+		if cfg.WeeklyRecapQuietWeek != WEEKLY_RECAP_QUIET_POST {
+			logger.Info("Nothing was tweeted this week, skipping the weekly recap")
+			return false, nil
+		}
+		thread = []string{"🗓️ A quiet week, nothing shipped this time " + DEFAULT_HASHTAG}
+	}
+
+	inReplyToTweetID := ""
+	for i, text := range thread {
+		createTweetRequest := twitter2.CreateTweetRequest{Text: text}
+		if inReplyToTweetID != "" {
+			createTweetRequest.Reply = &twitter2.CreateTweetReply{InReplyToTweetID: inReplyToTweetID}
+		}
+		account.waitForRateLimit(ctx, CREATE_TWEET_PATH, cfg.RateLimitMinRemaining, logger)
+		logger.Info("About to tweet the weekly recap", "part", i+1, "num_parts", len(thread), "message", text)
+		resp, err := account.twitter2Client.CreateTweet(ctx, createTweetRequest)
+		if err != nil && i == 0 {
+			return false, &writeError{err: err}
+		}
+		if err != nil {
+			logger.Warn("Could not tweet the rest of the weekly recap", "part", i+1, "num_parts", len(thread), "error", err)
+			break
+		}
+		if i == 0 {
+			if err := stateStore.PutValue(context.WithoutCancel(ctx), WEEKLY_RECAP_SENT_ON_KEY, today); err != nil {
+				return true, err
+			}
+			if err := forgetRecapTodos(context.WithoutCancel(ctx), stateStore, recapTodos); err != nil {
+				return true, err
+			}
+		}
+		if resp.Tweet == nil {
+			break
+		}
+		inReplyToTweetID = resp.Tweet.ID
+	}
+	return true, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+const (
+	MAX_TWEET_LENGTH              = 280
+	DEFAULT_HASHTAG               = "#buildinpublic"
+	CHECKMARK_PREFIX              = "✅ "
+	MAX_FIRST_TWEET_PREFIX_LENGTH = 100
+	// Twitter shortens every link to a t.co link of this length
+	TCO_URL_LENGTH = 23
+	// Where the hashtags go relative to the body
+	HASHTAG_POSITION_PREFIX = "prefix"
+	HASHTAG_POSITION_SUFFIX = "suffix"
+	// What BOT_DISCLOSURE="true" adds to tweets
+	DEFAULT_BOT_DISCLOSURE = "🤖 automated"
+)
+
+// Builds the tweet text for a todo. The first hashtag is always kept, any others are only added while they fit in a tweet.
+func buildTweetMessage(body string, hashtags []string, hashtagPosition string) string {
+	keptHashtags := []string{}
+	for i, hashtag := range hashtags {
+		if i > 0 && tweetLength(layoutTweetMessage(body, append(keptHashtags, hashtag), hashtagPosition)) > MAX_TWEET_LENGTH {
+			continue
+		}
+		keptHashtags = append(keptHashtags, hashtag)
+	}
+	return layoutTweetMessage(body, keptHashtags, hashtagPosition)
+}
+
+// Puts the hashtags before or after the body. The checkmark leads either way.
+func layoutTweetMessage(body string, hashtags []string, hashtagPosition string) string {
+	if len(hashtags) == 0 {
+		return CHECKMARK_PREFIX + body
+	}
+	if hashtagPosition == HASHTAG_POSITION_PREFIX {
+		return CHECKMARK_PREFIX + strings.Join(hashtags, " ") + " " + body
+	}
+	return CHECKMARK_PREFIX + body + " " + strings.Join(hashtags, " ")
+}
+
+// Like buildTweetMessage, but if the tweet would be too long the body is cut short (with an ellipsis) rather than the first hashtag
+func fitTweetMessage(body string, hashtags []string, hashtagPosition string) string {
+	return fitTweetMessageWithIntro("", body, hashtags, hashtagPosition)
+}
+
+// Like fitTweetMessage, with an intro on its own line above the todo. The intro also comes out of the body's share.
+func fitTweetMessageWithIntro(intro string, body string, hashtags []string, hashtagPosition string) string {
+	return fitTweetMessageWithin(intro, body, hashtags, hashtagPosition, MAX_TWEET_LENGTH)
+}
+
+// Like fitTweetMessageWithIntro, for when some of the tweet is set aside for something else, like a link
+func fitTweetMessageWithin(intro string, body string, hashtags []string, hashtagPosition string, maxLength int) string {
+	if intro != "" {
+		intro += "\n\n"
+	}
+	tweetMessage := intro + buildTweetMessage(body, hashtags, hashtagPosition)
+	if tweetLength(tweetMessage) <= maxLength {
+		return tweetMessage
+	}
+	// Only the first hashtag is guaranteed a spot, the others would have to come out of the body
+	firstHashtag := hashtags[:min(len(hashtags), 1)]
+	maxBodyLength := maxLength - tweetLength(intro) - tweetLength(layoutTweetMessage("", firstHashtag, hashtagPosition))
+	return intro + layoutTweetMessage(truncateToTweetLength(body, maxBodyLength), firstHashtag, hashtagPosition)
+}
+
+// Cuts text down to at most maxLength as Twitter counts it, ending it with an ellipsis if anything was removed
+func truncateToTweetLength(text string, maxLength int) string {
+	if tweetLength(text) <= maxLength {
+		return text
+	}
+	maxLength -= tweetLength("…")
+	length := 0
+	var truncated []rune
+	for _, r := range text {
+		length += tweetLength(string(r))
+		if length > maxLength {
+			break
+		}
+		truncated = append(truncated, r)
+	}
+	return strings.TrimRightFunc(string(truncated), unicode.IsSpace) + "…"
+}
+
+// Returns the bare host of a project's website, e.g. "myapp.com" for "https://www.myapp.com/about", or an empty string if
+// there's no usable website
+func projectDomain(websiteURL string) string {
+	websiteURL = strings.TrimSpace(websiteURL)
+	if websiteURL == "" {
+		return ""
+	}
+	// People often leave the scheme out, which would otherwise make the whole thing parse as a path
+	if !strings.Contains(websiteURL, "://") {
+		websiteURL = "https://" + websiteURL
+	}
+	parsedURL, err := url.Parse(websiteURL)
+	if err != nil || !strings.Contains(parsedURL.Hostname(), ".") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+}
+
+// Approximates how Twitter counts characters: most latin characters count as 1, everything else (CJK, emoji etc.) counts as 2
+func tweetLength(text string) int {
+	length := 0
+	for _, r := range text {
+		if r <= 4351 || (r >= 8192 && r <= 8205) || (r >= 8208 && r <= 8223) || (r >= 8242 && r <= 8247) {
+			length++
+		} else {
+			length += 2
+		}
+	}
+	return length
+}
+
+// Adds the links to the end of the text, one per line, returning the ones that didn't fit
+func appendLinks(text string, links []string) (string, []string) {
+	length := tweetLength(text)
+	leftOff := []string{}
+	for _, link := range links {
+		if length+1+TCO_URL_LENGTH > MAX_TWEET_LENGTH {
+			leftOff = append(leftOff, link)
+			continue
+		}
+		text += "\n" + link
+		length += 1 + TCO_URL_LENGTH
+	}
+	return text, leftOff
+}
+
+// Adds the disclosure to the end of the text on its own line, unless that would make the tweet too long
+func appendDisclosure(text string, disclosure string) (string, bool) {
+	withDisclosure := text + "\n" + disclosure
+	if tweetLength(withDisclosure) > MAX_TWEET_LENGTH {
+		return text, false
+	}
+	return withDisclosure, true
+}
+
+// Returns the body up to and including the end of its first sentence (or its first line, whichever comes first)
+func firstSentence(body string) string {
+	body = strings.TrimSpace(body)
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = strings.TrimSpace(body[:i])
+	}
+	runes := []rune(body)
+	for i, r := range runes {
+		if (r == '.' || r == '!' || r == '?') && (i == len(runes)-1 || unicode.IsSpace(runes[i+1])) {
+			return string(runes[:i+1])
+		}
+	}
+	return body
+}
+
+// Cuts text down to at most maxRunes runes, ending it with an ellipsis if anything was removed
+func truncateRunes(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+// Keeps only the first maxLines lines of the body, ending it with an ellipsis if any were dropped. A maxLines of 0 means no limit.
+func limitLines(body string, maxLines int) string {
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return body
+	}
+	return strings.TrimRight(strings.Join(lines[:maxLines], "\n"), " \t\r") + "…"
+}
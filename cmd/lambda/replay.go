@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+// Tweets a single todo no matter when it was completed or whether it was tweeted before, e.g. to recover from a
+// botched run. Only recorded in the state store when record is set.
+func replayTodo(ctx context.Context, todoID string, record bool) (Response, error) {
+	logger := newLogger().With("replay_todo_id", todoID)
+	defer flushTracing()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return makeAndLogErrorResponse(err.Error(), "invalid_config", logger), nil
+	}
+	if cfg.WIPAPIKey == "" || cfg.Twitter.validate() != nil {
+		return makeAndLogErrorResponse("Cannot replay the todo because some of the required evars are missing, set them and run it again", "missing_evars", logger), nil
+	}
+	if err := checkTwitterCredentialFormats(cfg); err != nil {
+		return makeAndLogErrorResponse(err.Error(), "invalid_twitter_credentials", logger), nil
+	}
+
+	wipClient, err := newWIPClient(cfg.WIPAPIKey, cfg, logger)
+	if err != nil {
+		return makeAndLogErrorResponse("Could not load the WIP cache file", "wip_cache_error", logger), err
+	}
+	// The WIP API can't fetch a todo by ID, so look for it among all of them. Private todos can't be replayed either.
+	publicTodos, err := fetchPublicTodos(wipClient)
+	if errors.Is(err, lib_wip.ErrUnauthorized) {
+		return makeAndLogErrorResponse("WIP rejected the API key, check WIP_API_KEY and that WIP_AUTH_SCHEME matches the kind of key it is", "wip_auth_error", logger), err
+	}
+	if errors.Is(err, lib_wip.ErrUnexpectedSchema) {
+		return makeAndLogErrorResponse("WIP returned a response in an unexpected format, it may have changed its API", "wip_unexpected_schema", logger), err
+	}
+	if err != nil {
+		return makeAndLogErrorResponse("Error getting completed todos from WIP", "wip_api_error", logger), err
+	}
+	project, todo, ok := findTodo(publicTodos, todoID)
+	if !ok {
+		return makeAndLogErrorResponse(fmt.Sprintf("Could not find a public todo with the ID %s", todoID), "todo_not_found", logger), nil
+	}
+
+	if cfg.ExpandEmojiShortcodes {
+		todo.Body = lib_text.ExpandShortcodes(todo.Body)
+	}
+	replayTweet := planTweet(project, todo, cfg, logger)
+
+	// Replays go through the same gates as a normal run: a dry run only reports the tweet, and an interactive one asks
+	// before it goes out
+	confirmer := newConfiguredConfirmer(&cfg, logger)
+	if cfg.DryRunDiff {
+		diff := buildDryRunDiff([]plannedTweet{replayTweet}, nil, nil, cfg)
+		logDryRunDiff(diff, logger)
+		return Response{Message: DRY_RUN_DIFF_MESSAGE, DryRunDiff: &diff}, nil
+	}
+	if confirmer != nil {
+		text, ok := confirmer.confirm(replayTweet)
+		if !ok {
+			logger.Info("Skipping todo", "todo_id", todo.ID, "reason", "declined")
+			return Response{Message: SUCCESS_MESSAGE, SkippedTodos: []skippedTodo{{ProjectID: project.ID, TodoID: todo.ID, Reason: "declined"}}}, nil
+		}
+		replayTweet.Text = text
+	}
+
+	twitterAccount := newTwitterRouter(cfg.Twitter, cfg.ProjectTwitterCredentials, cfg.TwitterUserAgent).accountFor(project.ID)
+	attachmentDownloader := lib_media.NewDownloader(time.Duration(cfg.AttachmentDownloadTimeoutSeconds)*time.Second, cfg.AttachmentDownloadRetries)
+	uploadStats := attachmentStats{}
+	if _, err := tweetTodo(ctx, replayTweet, twitterAccount, attachmentDownloader, nil, cfg, &uploadStats, logger); err != nil {
+		return makeAndLogPostErrorResponse(err, logger)
+	}
+
+	if record {
+		stateStore, err := newStateStore(ctx, cfg)
+		if err != nil {
+			return makeAndLogErrorResponse("Could not set up the state store", "state_store_error", logger), err
+		}
+		if err := stateStore.MarkProcessed(context.WithoutCancel(ctx), processedTweetID(twitterAccount, todo.ID)); err != nil {
+			return makeAndLogErrorResponse("Could not record the tweeted todo in the state store", "state_store_error", logger), err
+		}
+	}
+
+	logger.Info(SUCCESS_MESSAGE, "num_todos_tweeted", 1, "recorded", record)
+	return Response{Message: SUCCESS_MESSAGE, NumTodosTweeted: 1, NumAttachmentsUploaded: uploadStats.NumUploaded, AttachmentBytesUploaded: uploadStats.BytesUploaded}, nil
+}
+
+func findTodo(publicTodos []projectWithTodos, todoID string) (lib_wip.Project, lib_wip.Todo, bool) {
+	for _, projectTodos := range publicTodos {
+		for _, todo := range projectTodos.Todos {
+			if todo.ID == todoID {
+				return projectTodos.Project, todo, true
+			}
+		}
+	}
+	return lib_wip.Project{}, lib_wip.Todo{}, false
+}
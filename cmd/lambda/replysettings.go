@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Lets a todo override the reply settings inline, e.g. "Launched v2 !replies:following"
+var replySettingsMarkerPattern = regexp.MustCompile(`(?i)\s*!replies:(\S+)`)
+
+// Maps the accepted config values to what the v2 API expects. Everyone can reply by default, so that maps to leaving the setting out.
+var replySettingsValues = map[string]string{
+	"":               "",
+	"everyone":       "",
+	"mentioned":      "mentionedUsers",
+	"mentionedusers": "mentionedUsers",
+	"following":      "following",
+}
+
+func parseReplySettings(value string) (string, error) {
+	replySettings, ok := replySettingsValues[strings.ToLower(strings.TrimSpace(value))]
+	if !ok {
+		return "", fmt.Errorf("unknown reply settings %q, expected one of everyone, mentioned or following", value)
+	}
+	return replySettings, nil
+}
+
+// Removes the reply settings marker from the body, returning the cleaned body and the marker's value if there was one
+func extractReplySettingsMarker(body string) (string, string, bool) {
+	match := replySettingsMarkerPattern.FindStringSubmatch(body)
+	if match == nil {
+		return body, "", false
+	}
+	return strings.TrimSpace(replySettingsMarkerPattern.ReplaceAllString(body, "")), match[1], true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const RUN_RETRY_BACKOFF = 2 * time.Second
+
+// Gateway errors are the server's side failing for a moment, so they're retried unless RETRYABLE_ERRORS says otherwise
+var DEFAULT_RETRYABLE_ERRORS = []string{"502", "503", "504"}
+
+// How WIP and attachment download errors report the status code, e.g. "unexpected status code: 503"
+var statusCodeMessagePattern = regexp.MustCompile(`status code:? (\d{3})`)
+
+// Decides whether a run that failed with the error is worth another attempt
+type retryClassifier func(err error) bool
+
+// retryBudget caps how many retries a run makes in total, across run attempts and attachment downloads, so a few
+// flaky calls retrying on their own can't use up the whole execution window. A nil retryBudget allows every retry.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// 0 means no budget, leaving each kind of retry to its own limit
+func newRetryBudget(total int) *retryBudget {
+	if total == 0 {
+		return nil
+	}
+	return &retryBudget{remaining: total}
+}
+
+// Spends one retry from the budget, returning false once there are none left
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// writeError is the error from a request that creates something, like a tweet. Unless the request never reached the
+// server, it may have gone through before failing, e.g. a timeout waiting for the answer, so running again could
+// create it twice.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
+
+// Reports whether the request never reached the server: the name didn't resolve or no connection could be made
+func isDialError(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// Reports whether the error came from not reaching a server at all, like a DNS failure, a refused or reset connection
+// or a timeout. Those are always worth retrying.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Writes are only retried when they never reached the server. Otherwise it retries connection errors plus the errors
+// matching one of retryableErrors. An entry that's a number matches that HTTP
+// status code from WIP or Twitter, anything else matches errors whose message contains it, ignoring case. A server
+// answering with any other error would only answer the same way again.
+func newRetryClassifier(retryableErrors []string) retryClassifier {
+	statusCodes := map[int]bool{}
+	messages := []string{}
+	for _, retryableError := range retryableErrors {
+		retryableError = strings.TrimSpace(retryableError)
+		if statusCode, err := strconv.Atoi(retryableError); err == nil {
+			statusCodes[statusCode] = true
+		} else if retryableError != "" {
+			messages = append(messages, strings.ToLower(retryableError))
+		}
+	}
+	return func(err error) bool {
+		var writeErr *writeError
+		if errors.As(err, &writeErr) {
+			return isDialError(err)
+		}
+		if isConnectionError(err) {
+			return true
+		}
+		if statusCode, ok := errorStatusCode(err); ok && statusCodes[statusCode] {
+			return true
+		}
+		message := strings.ToLower(err.Error())
+		for _, retryableMessage := range messages {
+			if strings.Contains(message, retryableMessage) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns the HTTP status code the server answered with, if the error carries one
+func errorStatusCode(err error) (int, bool) {
+	var httpErr *twitter2.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode, true
+	}
+	var errorResponse *twitter2.ErrorResponse
+	if errors.As(err, &errorResponse) {
+		return errorResponse.StatusCode, true
+	}
+	if match := statusCodeMessagePattern.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ := strconv.Atoi(match[1])
+		return statusCode, true
+	}
+	return 0, false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Parses daily posting times like "09:00", returning them in minutes since midnight, earliest first
+func parsePostingTimes(values []string) ([]int, error) {
+	postingTimes := []int{}
+	for _, value := range values {
+		postingTime, err := time.Parse("15:04", strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid posting time %q", value)
+		}
+		postingTimes = append(postingTimes, postingTime.Hour()*60+postingTime.Minute())
+	}
+	sort.Ints(postingTimes)
+	return postingTimes, nil
+}
+
+// The first of the daily posting times after now, on the wall clock in the location so they follow DST changes. This
+// is when todos queued now are scheduled for.
+func nextPostingTime(postingTimes []int, now time.Time, location *time.Location) time.Time {
+	localNow := now.In(location)
+	for day := 0; day <= 1; day++ {
+		for _, minuteOfDay := range postingTimes {
+			postingTime := time.Date(localNow.Year(), localNow.Month(), localNow.Day()+day, minuteOfDay/60, minuteOfDay%60, 0, 0, location)
+			if postingTime.After(now) {
+				return postingTime.UTC()
+			}
+		}
+	}
+	return time.Time{}
+}
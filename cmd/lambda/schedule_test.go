@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParsePostingTimes(t *testing.T) {
+	postingTimes, err := parsePostingTimes([]string{"18:00", " 09:30", "13:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{9*60 + 30, 13 * 60, 18 * 60}; !slices.Equal(postingTimes, want) {
+		t.Errorf("got posting times %v, want %v", postingTimes, want)
+	}
+	if _, err := parsePostingTimes([]string{"9am"}); err == nil {
+		t.Errorf("parsed a posting time that isn't like 09:00")
+	}
+}
+
+func TestNextPostingTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postingTimes := []int{9 * 60, 18 * 60}
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 10, 12, 8, 0, 0, 0, newYork), time.Date(2026, 10, 12, 9, 0, 0, 0, newYork)},
+		{time.Date(2026, 10, 12, 9, 0, 0, 0, newYork), time.Date(2026, 10, 12, 18, 0, 0, 0, newYork)},
+		{time.Date(2026, 10, 12, 21, 0, 0, 0, newYork), time.Date(2026, 10, 13, 9, 0, 0, 0, newYork)},
+		// Clocks go back overnight, and the posting time stays at 9 on the wall clock
+		{time.Date(2026, 10, 31, 21, 0, 0, 0, newYork), time.Date(2026, 11, 1, 9, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		if got := nextPostingTime(postingTimes, test.now.UTC(), newYork); !got.Equal(test.want) {
+			t.Errorf("nextPostingTime at %s = %s, want %s", test.now, got.In(newYork), test.want)
+		}
+	}
+}
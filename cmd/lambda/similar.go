@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+)
+
+const (
+	// The bodies of the most recently tweeted todos, newest last
+	RECENT_TWEET_BODIES_KEY             = "recent_tweet_bodies"
+	DEFAULT_NEAR_DUPLICATE_HISTORY_SIZE = 20
+)
+
+func parseRecentTweetBodies(value string) ([]string, error) {
+	recentBodies := []string{}
+	if value == "" {
+		return recentBodies, nil
+	}
+	if err := json.Unmarshal([]byte(value), &recentBodies); err != nil {
+		return nil, fmt.Errorf("could not unmarshal the recent tweet bodies: %w", err)
+	}
+	return recentBodies, nil
+}
+
+func loadRecentTweetBodies(ctx context.Context, stateStore lib_state.Store) ([]string, error) {
+	value, err := stateStore.GetValue(ctx, RECENT_TWEET_BODIES_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecentTweetBodies(value)
+}
+
+// Adds the body to the recent ones in the state store, keeping only the newest historySize. Returns them all, including
+// any that overlapping runs tweeted since they were loaded.
+func saveRecentTweetBody(ctx context.Context, stateStore lib_state.Store, body string, historySize int) ([]string, error) {
+	var recentBodies []string
+	err := stateStore.UpdateValue(ctx, RECENT_TWEET_BODIES_KEY, func(value string) (string, error) {
+		var err error
+		recentBodies, err = parseRecentTweetBodies(value)
+		if err != nil {
+			return "", err
+		}
+		recentBodies = append(recentBodies, body)
+		if len(recentBodies) > historySize {
+			recentBodies = recentBodies[len(recentBodies)-historySize:]
+		}
+		newValue, err := json.Marshal(recentBodies)
+		return string(newValue), err
+	})
+	return recentBodies, err
+}
+
+// Returns the recently tweeted body that's at least threshold similar to this one, if there is one
+func findNearDuplicate(body string, recentBodies []string, threshold int) (string, bool) {
+	for i := len(recentBodies) - 1; i >= 0; i-- {
+		if lib_text.TokenSetRatio(body, recentBodies[i]) >= threshold {
+			return recentBodies[i], true
+		}
+	}
+	return "", false
+}
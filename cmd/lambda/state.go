@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+)
+
+const (
+	STATE_BACKEND_MEMORY   = "memory"
+	STATE_BACKEND_S3       = "s3"
+	STATE_BACKEND_DYNAMODB = "dynamodb"
+	DEFAULT_STATE_S3_KEY   = "wip-to-twitter-bridge/state.json"
+	// Todos are only tweeted within an hour or so of being completed, or once a quiet period or the approval queue lets
+	// them, so a month of processed records is plenty to keep them from being tweeted twice
+	DEFAULT_STATE_S3_RETENTION_DAYS = 30
+	DEFAULT_RUN_LOCK_NAME           = "wip-to-twitter-bridge"
+	// Lambda functions can't run for longer than 15 minutes
+	DEFAULT_RUN_LOCK_TTL_SECONDS = 15 * 60
+)
+
+func newStateStore(ctx context.Context, cfg Config) (lib_state.Store, error) {
+	switch cfg.StateBackend {
+	case STATE_BACKEND_S3:
+		awsConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not load AWS config: %w", err)
+		}
+		s3Store := lib_state.NewS3Store(s3.NewFromConfig(awsConfig), cfg.StateS3Bucket, cfg.StateS3Key)
+		s3Store.SetRetention(time.Duration(cfg.StateS3RetentionDays) * 24 * time.Hour)
+		return s3Store, nil
+	case STATE_BACKEND_DYNAMODB:
+		awsConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not load AWS config: %w", err)
+		}
+		return lib_state.NewDynamoDBStore(dynamodb.NewFromConfig(awsConfig), cfg.StateDynamoDBTable), nil
+	default:
+		return lib_state.NewMemoryStore(), nil
+	}
+}
+
+// Identifies this run, e.g. as the owner of the run lock
+func runID(ctx context.Context) string {
+	if lambdaContext, ok := lambdacontext.FromContext(ctx); ok {
+		return lambdaContext.AwsRequestID
+	}
+	randomBytes := make([]byte, 16)
+	rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
+
+// With RUN_LOCK_PER_ACCOUNT each account has its own lock, named after the run lock and the account's user ID
+func accountLockName(runLockName string, userID string) string {
+	return runLockName + "#" + userID
+}
+
+// Acquires the lock of each account that can be tweeted from, returning the names of the locks it got and the user IDs
+// of the accounts another run is busy with. Runs tweeting from different accounts can then work at the same time, and
+// a run that only got some of the locks still tweets from those accounts.
+func acquireAccountLocks(ctx context.Context, locker lib_state.Locker, cfg Config, owner string) ([]string, map[string]bool, error) {
+	userIDs := map[string]bool{cfg.Twitter.userID(): true}
+	for _, credentials := range cfg.ProjectTwitterCredentials {
+		userIDs[credentials.userID()] = true
+	}
+	acquiredLockNames := []string{}
+	busyAccounts := map[string]bool{}
+	for userID := range userIDs {
+		lockName := accountLockName(cfg.RunLockName, userID)
+		acquired, err := locker.AcquireLock(ctx, lockName, owner, time.Duration(cfg.RunLockTTLSeconds)*time.Second)
+		if err != nil {
+			return acquiredLockNames, nil, err
+		}
+		if !acquired {
+			busyAccounts[userID] = true
+			continue
+		}
+		acquiredLockNames = append(acquiredLockNames, lockName)
+	}
+	return acquiredLockNames, busyAccounts, nil
+}
+
+// Holds back the planned tweets for accounts another run is busy with. They aren't recorded anywhere, so the next run
+// that gets the account's lock picks them up.
+func dropBusyAccounts(plannedTweets []plannedTweet, router *twitterRouter, busyAccounts map[string]bool, logger *slog.Logger) ([]plannedTweet, []skippedTodo) {
+	remaining := []plannedTweet{}
+	skippedTodos := []skippedTodo{}
+	for _, plannedTweet := range plannedTweets {
+		if busyAccounts[router.accountFor(plannedTweet.Project.ID).UserID] {
+			logger.Info("Skipping todo", "todo_id", plannedTweet.Todo.ID, "reason", "account_busy")
+			skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "account_busy"})
+			continue
+		}
+		remaining = append(remaining, plannedTweet)
+	}
+	return remaining, skippedTodos
+}
+
+// The same todo can be tweeted once from each account it's routed to, so dedup is per account
+func processedTweetID(account *twitterAccount, todoID string) string {
+	return "tweet#" + account.UserID + "#" + todoID
+}
+
+// The todo ID back out of an ID made by processedTweetID
+func todoIDFromProcessedTweetID(processedID string) (string, bool) {
+	if !strings.HasPrefix(processedID, "tweet#") {
+		return "", false
+	}
+	separator := strings.LastIndex(processedID, "#")
+	return processedID[separator+1:], separator > len("tweet#")
+}
+
+// Drops the planned tweets whose todo was already tweeted from the account it would be tweeted from now
+func dropAlreadyTweeted(ctx context.Context, stateStore lib_state.Store, router *twitterRouter, plannedTweets []plannedTweet, logger *slog.Logger) ([]plannedTweet, []skippedTodo, error) {
+	remaining := []plannedTweet{}
+	skippedTodos := []skippedTodo{}
+	for _, plannedTweet := range plannedTweets {
+		alreadyTweeted, err := stateStore.IsProcessed(ctx, processedTweetID(router.accountFor(plannedTweet.Project.ID), plannedTweet.Todo.ID))
+		if err != nil {
+			return nil, nil, err
+		}
+		if alreadyTweeted {
+			logger.Info("Skipping todo", "todo_id", plannedTweet.Todo.ID, "reason", "already_tweeted")
+			skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "already_tweeted"})
+			continue
+		}
+		remaining = append(remaining, plannedTweet)
+	}
+	return remaining, skippedTodos, nil
+}
+
+// Where the ID of the latest tweet in a project's thread is kept. Like processed todos, threads are per account.
+func projectThreadKey(account *twitterAccount, projectID string) string {
+	return "thread#" + account.UserID + "#" + projectID
+}
+
+// Set once a baseline has been established so a first run that found nothing to mark doesn't make the next run a first run too
+const BASELINE_ESTABLISHED_AT_KEY = "baseline_established_at"
+
+func isFirstRun(ctx context.Context, stateStore lib_state.Store) (bool, error) {
+	hasAnyProcessed, err := stateStore.HasAnyProcessed(ctx)
+	if err != nil || hasAnyProcessed {
+		return false, err
+	}
+	baselineEstablishedAt, err := stateStore.GetValue(ctx, BASELINE_ESTABLISHED_AT_KEY)
+	return baselineEstablishedAt == "", err
+}
+
+// Marks every planned tweet as processed without tweeting it, so only todos completed after the first run get tweeted
+func establishBaseline(ctx context.Context, stateStore lib_state.Store, plannedTweets []plannedTweet, router *twitterRouter, now time.Time) ([]skippedTodo, error) {
+	var skippedTodos []skippedTodo
+	for _, plannedTweet := range plannedTweets {
+		processedID := processedTweetID(router.accountFor(plannedTweet.Project.ID), plannedTweet.Todo.ID)
+		if err := stateStore.MarkProcessed(ctx, processedID); err != nil {
+			return nil, err
+		}
+		skippedTodos = append(skippedTodos, skippedTodo{ProjectID: plannedTweet.Project.ID, TodoID: plannedTweet.Todo.ID, Reason: "first_run_baseline"})
+	}
+	if err := stateStore.PutValue(ctx, BASELINE_ESTABLISHED_AT_KEY, now.Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	return skippedTodos, nil
+}
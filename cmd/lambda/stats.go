@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+// The date the last stats tweet went out, so a retried or extra run in the same hour doesn't send it twice
+const DAILY_STATS_SENT_ON_KEY = "daily_stats_sent_on"
+
+// Counts the todos the state store recorded since midnight in the configured timezone, and how many projects they're
+// spread across. That's mostly tweeted todos, but also ones recorded without being tweeted, like rejected ones, which were
+// completed all the same. A todo tweeted from more than one account counts once. The records only have the todo's ID, so
+// its project is looked up among the fetched todos, and one that's no longer among them only counts toward the todos.
+func countTodosTweetedToday(ctx context.Context, stateStore lib_state.Store, publicTodos []projectWithTodos, now time.Time, location *time.Location) (int, int, error) {
+	localNow := now.In(location)
+	startOfDay := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), 0, 0, 0, 0, location)
+	processedIDs, err := stateStore.ProcessedSince(ctx, startOfDay)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	projectIDsByTodoID := map[string]string{}
+	for _, projectTodos := range publicTodos {
+		for _, todo := range projectTodos.Todos {
+			projectIDsByTodoID[todo.ID] = projectTodos.Project.ID
+		}
+	}
+	todoIDs := map[string]bool{}
+	projectIDs := map[string]bool{}
+	for _, processedID := range processedIDs {
+		todoID, ok := todoIDFromProcessedTweetID(processedID)
+		if !ok {
+			continue
+		}
+		todoIDs[todoID] = true
+		if projectID, ok := projectIDsByTodoID[todoID]; ok {
+			projectIDs[projectID] = true
+		}
+	}
+	return len(todoIDs), len(projectIDs), nil
+}
+
+// The stats tweet goes out on the run that falls in the configured hour, which is the last run of the day by default
+func isDailyStatsRun(cfg Config, now time.Time) bool {
+	return cfg.DailyStatsTweet && now.In(cfg.location).Hour() == cfg.DailyStatsHour
+}
+
+// Tweets how many todos were completed today, returning whether anything was tweeted
+func tweetDailyStats(ctx context.Context, stateStore lib_state.Store, account *twitterAccount, publicTodos []projectWithTodos, cfg Config, now time.Time, logger *slog.Logger) (bool, error) {
+	today := now.In(cfg.location).Format(time.DateOnly)
+	sentOn, err := stateStore.GetValue(ctx, DAILY_STATS_SENT_ON_KEY)
+	if err != nil || sentOn == today {
+		return false, err
+	}
+	numTodosToday, numProjectsToday, err := countTodosTweetedToday(ctx, stateStore, publicTodos, now, cfg.location)
+	if err != nil || numTodosToday == 0 {
+		return false, err
+	}
+
+	statsMessage := buildDailyStatsMessage(numTodosToday, numProjectsToday)
+	account.waitForRateLimit(ctx, CREATE_TWEET_PATH, cfg.RateLimitMinRemaining, logger)
+	logger.Info("About to tweet the daily stats", "message", statsMessage)
+	if _, err := account.twitter2Client.CreateTweet(ctx, twitter2.CreateTweetRequest{Text: statsMessage}); err != nil {
+		return false, &writeError{err: err}
+	}
+	if err := stateStore.PutValue(context.WithoutCancel(ctx), DAILY_STATS_SENT_ON_KEY, today); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+func buildDailyStatsMessage(numTodos int, numProjects int) string {
+	if numProjects == 0 {
+		return fmt.Sprintf("📊 %d %s completed today %s", numTodos, pluralize(numTodos, "todo", "todos"), DEFAULT_HASHTAG)
+	}
+	return fmt.Sprintf("📊 %d %s completed across %d %s today %s", numTodos, pluralize(numTodos, "todo", "todos"), numProjects, pluralize(numProjects, "project", "projects"), DEFAULT_HASHTAG)
+}
+
+func pluralize(count int, singular string, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
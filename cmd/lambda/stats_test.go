@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	lib_state "github.com/bakatz/wip-to-twitter-bridge/lib/state"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+)
+
+func TestCountTodosTweetedToday(t *testing.T) {
+	ctx := context.Background()
+	stateStore := lib_state.NewMemoryStore()
+	defaultAccount := &twitterAccount{UserID: "1"}
+	projectAccount := &twitterAccount{UserID: "2"}
+	for _, processedID := range []string{
+		processedTweetID(defaultAccount, "t1"),
+		processedTweetID(defaultAccount, "t2"),
+		// Tweeted from both accounts, but it's still one todo
+		processedTweetID(projectAccount, "t2"),
+		processedTweetID(projectAccount, "t3"),
+		// No longer on the first page of its project
+		processedTweetID(defaultAccount, "t4"),
+	} {
+		if err := stateStore.MarkProcessed(ctx, processedID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publicTodos := []projectWithTodos{
+		{Project: lib_wip.Project{ID: "p1"}, Todos: []lib_wip.Todo{{ID: "t1"}, {ID: "t2"}, {ID: "t0"}}},
+		{Project: lib_wip.Project{ID: "p2"}, Todos: []lib_wip.Todo{{ID: "t3"}}},
+		{Project: lib_wip.Project{ID: "p3"}, Todos: []lib_wip.Todo{{ID: "t5"}}},
+	}
+
+	numTodos, numProjects, err := countTodosTweetedToday(ctx, stateStore, publicTodos, time.Now(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numTodos != 4 || numProjects != 2 {
+		t.Errorf("got %d todos across %d projects, want 4 across 2", numTodos, numProjects)
+	}
+
+	// Nothing was recorded tomorrow yet
+	numTodos, _, err = countTodosTweetedToday(ctx, stateStore, publicTodos, time.Now().Add(24*time.Hour), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numTodos != 0 {
+		t.Errorf("got %d todos for tomorrow, want 0", numTodos)
+	}
+}
+
+func TestTodoIDFromProcessedTweetID(t *testing.T) {
+	if todoID, ok := todoIDFromProcessedTweetID(processedTweetID(&twitterAccount{UserID: "123"}, "abc")); !ok || todoID != "abc" {
+		t.Errorf("got %q, %v, want abc", todoID, ok)
+	}
+	for _, processedID := range []string{"tweet#abc", "other#123#abc"} {
+		if _, ok := todoIDFromProcessedTweetID(processedID); ok {
+			t.Errorf("%q isn't a processed tweet ID", processedID)
+		}
+	}
+}
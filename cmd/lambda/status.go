@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+)
+
+const (
+	DEFAULT_STATUS_EMOJI_TWEETED = "✅"
+	DEFAULT_STATUS_EMOJI_SKIPPED = "⏭️"
+	DEFAULT_STATUS_EMOJI_FAILED  = "❌"
+)
+
+// Starts a log message with the emoji for how a todo went, so tweeted, skipped and failed todos stand apart when
+// scanning a run's logs. An empty emoji leaves the message as it is.
+func withStatusEmoji(emoji string, message string) string {
+	if emoji == "" {
+		return message
+	}
+	return emoji + " " + message
+}
+
+// Logs one line per todo the run tweeted or skipped, after the fact, so they're all together at the end of the run
+func logTodoStatuses(tweetedTodos []tweetedTodo, skippedTodos []skippedTodo, cfg Config, logger *slog.Logger) {
+	for _, tweeted := range tweetedTodos {
+		logger.Info(withStatusEmoji(cfg.StatusEmojiTweeted, "Tweeted todo"), "project_id", tweeted.ProjectID, "todo_id", tweeted.TodoID, "tweet_id", tweeted.TweetID)
+	}
+	for _, skipped := range skippedTodos {
+		logger.Info(withStatusEmoji(cfg.StatusEmojiSkipped, "Skipped todo"), "project_id", skipped.ProjectID, "todo_id", skipped.TodoID, "reason", skipped.Reason)
+	}
+}
+
+// A failed todo ends the run, so it's logged where it fails rather than in the summary
+func logTodoFailed(plannedTweet plannedTweet, err error, cfg Config, logger *slog.Logger) {
+	for _, todo := range plannedTweet.todos() {
+		logger.Error(withStatusEmoji(cfg.StatusEmojiFailed, "Failed todo"), "project_id", plannedTweet.Project.ID, "todo_id", todo.ID, "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	lib_text "github.com/bakatz/wip-to-twitter-bridge/lib/text"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const (
+	// The timeline endpoint returns between 5 and 100 tweets per call
+	MIN_TIMELINE_DEDUP_SIZE = 5
+	MAX_TIMELINE_DEDUP_SIZE = 100
+)
+
+// The texts of each account's latest tweets, fetched the first time they're needed and kept for the rest of the run
+type timelineCache struct {
+	size   int
+	texts  map[string][]string
+	logger *slog.Logger
+}
+
+func newTimelineCache(size int, logger *slog.Logger) *timelineCache {
+	return &timelineCache{size: size, texts: map[string][]string{}, logger: logger}
+}
+
+// Returns the texts of the account's latest tweets, newest last like the recent tweet bodies. The timeline is only an
+// extra source for dedup, so if it can't be read (e.g. the API plan doesn't include reads) it's treated as empty.
+func (c *timelineCache) textsFor(ctx context.Context, account *twitterAccount) []string {
+	if texts, ok := c.texts[account.UserID]; ok {
+		return texts
+	}
+	texts := []string{}
+	resp, err := account.twitter2Client.UserTweetTimeline(ctx, account.UserID, twitter2.UserTweetTimelineOpts{MaxResults: c.size})
+	if err != nil {
+		c.logger.Warn("Could not read the account's timeline, only checking for duplicates among tweets the bridge sent", "twitter_user_id", account.UserID, "error", err)
+	} else if resp.Raw != nil {
+		for i := len(resp.Raw.Tweets) - 1; i >= 0; i-- {
+			if resp.Raw.Tweets[i] != nil {
+				texts = append(texts, resp.Raw.Tweets[i].Text)
+			}
+		}
+	}
+	c.texts[account.UserID] = texts
+	return texts
+}
+
+// Returns the timeline text the todo's body was already tweeted as, if there is one. With a threshold the texts are
+// matched like near duplicates. Without one a text has to have the same words in the same order, since a short todo
+// like "Fixed tests" has all of its words in plenty of tweets that are about something else.
+func findOnTimeline(body string, timelineTexts []string, threshold int) (string, bool) {
+	if threshold > 0 {
+		return findNearDuplicate(body, timelineTexts, threshold)
+	}
+	normalizedBody := lib_text.Normalize(body)
+	if normalizedBody == "" {
+		return "", false
+	}
+	for i := len(timelineTexts) - 1; i >= 0; i-- {
+		if lib_text.Normalize(timelineTexts[i]) == normalizedBody {
+			return timelineTexts[i], true
+		}
+	}
+	return "", false
+}
+
+// Tweets sent during the run are on the timeline too
+func (c *timelineCache) add(account *twitterAccount, text string) {
+	if texts, ok := c.texts[account.UserID]; ok {
+		c.texts[account.UserID] = append(texts, text)
+	}
+}
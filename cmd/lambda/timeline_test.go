@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestFindOnTimeline(t *testing.T) {
+	timelineTexts := []string{
+		"Fixed tests for the parser and refactored the lexer #buildinpublic",
+		"Refactoring day, nothing to show yet",
+		"Shipped dark mode 🌙 https://t.co/abc123 #buildinpublic @wip",
+	}
+	tests := []struct {
+		name      string
+		body      string
+		threshold int
+		want      string
+		wantFound bool
+	}{
+		{"short todo whose words are in a longer tweet", "Fixed tests", 0, "", false},
+		{"one word todo", "Refactoring", 0, "", false},
+		{"same words without the extras", "shipped dark mode!", 0, timelineTexts[2], true},
+		{"same words in another order", "dark mode shipped", 0, "", false},
+		{"nothing but punctuation", "...", 0, "", false},
+		{"near duplicate with a threshold", "Fixed tests", 100, timelineTexts[0], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := findOnTimeline(tt.body, timelineTexts, tt.threshold)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("findOnTimeline(%q) = %q, %v, want %q, %v", tt.body, got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestFindOnTimelineAfterAddingTheRunsOwnTweet(t *testing.T) {
+	account := &twitterAccount{UserID: "1"}
+	timeline := newTimelineCache(MIN_TIMELINE_DEDUP_SIZE, nil)
+	timeline.texts[account.UserID] = []string{}
+	timeline.add(account, "Fixed tests #buildinpublic")
+
+	if _, found := findOnTimeline("Fixed tests in CI", timeline.texts[account.UserID], 0); found {
+		t.Errorf("a recurring todo with more words was matched by the run's own tweet")
+	}
+	if _, found := findOnTimeline("Fixed tests", timeline.texts[account.UserID], 0); !found {
+		t.Errorf("the same todo wasn't matched by the run's own tweet")
+	}
+}
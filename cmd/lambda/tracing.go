@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const TRACER_NAME = "github.com/bakatz/wip-to-twitter-bridge"
+
+// Spans are no-ops unless setupTracing installed a real provider
+var tracer = otel.Tracer(TRACER_NAME)
+
+// Only set when tracing is enabled
+var tracerProvider *sdktrace.TracerProvider
+
+// Exports spans over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set. The exporter reads the endpoint and any
+// other OTEL_EXPORTER_OTLP_* settings from the environment itself.
+func setupTracing(ctx context.Context) error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("wip-to-twitter-bridge"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	tracer = tracerProvider.Tracer(TRACER_NAME)
+	return nil
+}
+
+// Lambda freezes the process as soon as the handler returns, so spans have to be exported before then
+func flushTracing() {
+	if tracerProvider != nil {
+		tracerProvider.ForceFlush(context.Background())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	lib_translate "github.com/bakatz/wip-to-twitter-bridge/lib/translate"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const (
+	// The translation replies to the original tweet
+	TRANSLATE_MODE_REPLY = "reply"
+	// The translation is its own tweet, tagged with the language
+	TRANSLATE_MODE_SEPARATE = "separate"
+)
+
+// Tweets a translation of a todo that was just tweeted. The original is already out, so failures are logged rather than
+// failing the run. Returns whether the translation was tweeted.
+func tweetTranslation(ctx context.Context, translator *lib_translate.Client, plannedTweet plannedTweet, originalTweetID string, twitterAccount *twitterAccount, cfg Config, logger *slog.Logger) bool {
+	translatedBody, err := translator.Translate(limitLines(plannedTweet.Todo.Body, cfg.MaxBodyLines), cfg.TranslateTargetLanguage)
+	if err != nil {
+		logger.Warn("Could not translate the todo, only the original was tweeted", "todo_id", plannedTweet.Todo.ID, "error", err)
+		return false
+	}
+
+	createTweetRequest := twitter2.CreateTweetRequest{ReplySettings: plannedTweet.ReplySettings}
+	if cfg.TranslateMode == TRANSLATE_MODE_REPLY && originalTweetID != "" {
+		createTweetRequest.Text = fitTweetMessage(translatedBody, nil, cfg.HashtagPosition)
+		createTweetRequest.Reply = &twitter2.CreateTweetReply{InReplyToTweetID: originalTweetID}
+	} else {
+		languageHashtag := "#" + strings.ToUpper(cfg.TranslateTargetLanguage)
+		createTweetRequest.Text = fitTweetMessage(translatedBody, append([]string{languageHashtag}, plannedTweet.Hashtags...), cfg.HashtagPosition)
+	}
+
+	twitterAccount.waitForRateLimit(ctx, CREATE_TWEET_PATH, cfg.RateLimitMinRemaining, logger)
+	logger.Info("About to tweet this translation", "message", createTweetRequest.Text, "twitter_user_id", twitterAccount.UserID)
+	if _, err := twitterAccount.twitter2Client.CreateTweet(context.WithoutCancel(ctx), createTweetRequest); err != nil {
+		logger.Warn("Could not tweet the translation, only the original was tweeted", "todo_id", plannedTweet.Todo.ID, "error", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	lib_render "github.com/bakatz/wip-to-twitter-bridge/lib/render"
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Totals for the attachments uploaded during a run
+type attachmentStats struct {
+	NumUploaded   int
+	BytesUploaded int
+	// Attachments that weren't uploaded because the same file already had been this run
+	NumDeduplicated int
+}
+
+// Reports whether Twitter turned the tweet down, so nothing was posted and it's safe to send it again without whatever
+// Twitter objected to. A timeout, rate limit or server error may have come after the tweet was created, so those aren't
+// rejections and the tweet isn't sent again.
+func isTwitterRejection(err error) bool {
+	var httpErr *twitter2.HTTPError
+	var errorResponse *twitter2.ErrorResponse
+	statusCode := 0
+	if errors.As(err, &httpErr) {
+		statusCode = httpErr.StatusCode
+	} else if errors.As(err, &errorResponse) {
+		statusCode = errorResponse.StatusCode
+	}
+	return statusCode == http.StatusBadRequest || statusCode == http.StatusForbidden
+}
+
+// Uploads the media for a planned tweet and sends it, returning the ID of the new tweet. Attachment uploads are added to stats.
+func tweetTodo(ctx context.Context, plannedTweet plannedTweet, twitterAccount *twitterAccount, downloader *lib_media.Downloader, mediaCache *mediaCache, cfg Config, stats *attachmentStats, logger *slog.Logger) (string, error) {
+	todoAttributes := trace.WithAttributes(attribute.String("project.id", plannedTweet.Project.ID), attribute.String("todo.id", plannedTweet.Todo.ID))
+	ctx, span := tracer.Start(ctx, "tweet_todo", todoAttributes)
+	defer span.End()
+
+	// A retry after the tweet itself failed reuses the media the failed attempt uploaded
+	media, ok := mediaCache.load(ctx, twitterAccount, plannedTweet.Todo.ID, time.Now(), logger)
+	if ok {
+		logger.Info("Reusing the media uploaded by an earlier attempt", "todo_id", plannedTweet.Todo.ID, "media_ids", media.MediaIDs)
+	} else {
+		var err error
+		media, err = uploadTodoMedia(ctx, plannedTweet, twitterAccount, downloader, cfg, stats, logger)
+		if err != nil {
+			return "", err
+		}
+		mediaCache.save(context.WithoutCancel(ctx), twitterAccount, plannedTweet.Todo.ID, media, time.Now(), logger)
+	}
+	mediaIDs := media.MediaIDs
+	hasMedia := len(mediaIDs) > 0
+	// Text left room for the changelog link, so it always makes it in
+	links := media.OversizeLinks
+	if plannedTweet.ChangelogURL != "" {
+		links = append([]string{plannedTweet.ChangelogURL}, links...)
+	}
+	text, leftOffLinks := appendLinks(plannedTweet.Text, links)
+	if len(leftOffLinks) > 0 {
+		logger.Warn("No room left in the tweet for links to the attachments that were too large", "todo_id", plannedTweet.Todo.ID, "urls", leftOffLinks)
+	}
+	// The disclosure goes last, once everything that has to be in the tweet is
+	if cfg.botDisclosure != "" {
+		var disclosed bool
+		text, disclosed = appendDisclosure(text, cfg.botDisclosure)
+		if !disclosed {
+			logger.Info("No room left in the tweet for the bot disclosure, leaving it off", "todo_id", plannedTweet.Todo.ID)
+		}
+	}
+
+	// The media is posted once the tweet itself is out, so there's something to reply to
+	replyMediaIDs := []string{}
+	if cfg.MediaAsReply {
+		replyMediaIDs, mediaIDs = mediaIDs, nil
+	}
+
+	logger.Info("About to tweet this message", "message", text, "twitter_user_id", twitterAccount.UserID)
+
+	createTweetRequest := &twitter2.CreateTweetRequest{
+		Text:          text,
+		ReplySettings: plannedTweet.ReplySettings,
+	}
+
+	if plannedTweet.InReplyToTweetID != "" {
+		createTweetRequest.Reply = &twitter2.CreateTweetReply{InReplyToTweetID: plannedTweet.InReplyToTweetID}
+	}
+
+	if len(plannedTweet.PollOptions) > 0 {
+		createTweetRequest.Poll = &twitter2.CreateTweetPoll{Options: plannedTweet.PollOptions, DurationMinutes: cfg.PollDurationMinutes}
+	}
+
+	if len(mediaIDs) > 0 {
+		createTweetRequest.Media = &twitter2.CreateTweetMedia{
+			IDs: mediaIDs,
+		}
+	}
+
+	twitterAccount.waitForRateLimit(ctx, CREATE_TWEET_PATH, cfg.RateLimitMinRemaining, logger)
+	// Once we've started tweeting, finish even if we're asked to shut down
+	createCtx, createSpan := tracer.Start(context.WithoutCancel(ctx), "create_tweet", todoAttributes)
+	defer createSpan.End()
+	createTweet := twitterAccount.twitter2Client.CreateTweet
+	if createTweetRequest.Poll != nil {
+		createTweet = func(ctx context.Context, request twitter2.CreateTweetRequest) (*twitter2.CreateTweetResponse, error) {
+			return createPollTweet(ctx, twitterAccount.twitter2Client, request)
+		}
+	}
+	resp, err := createTweet(createCtx, *createTweetRequest)
+	// Not every account can post polls, so if Twitter rejects the poll post the todo as a normal tweet instead
+	if isTwitterRejection(err) && createTweetRequest.Poll != nil {
+		logger.Warn("Twitter rejected the poll, retrying as a normal tweet", "poll_options", createTweetRequest.Poll.Options, "error", err)
+		createTweetRequest.Poll = nil
+		createTweet = twitterAccount.twitter2Client.CreateTweet
+		resp, err = createTweet(createCtx, *createTweetRequest)
+	}
+	// Restricting replies isn't available to every account, so rather than losing the tweet fall back to letting everyone reply
+	if isTwitterRejection(err) && createTweetRequest.ReplySettings != "" {
+		logger.Warn("Twitter rejected the tweet with reply settings, retrying without them", "reply_settings", createTweetRequest.ReplySettings, "error", err)
+		createTweetRequest.ReplySettings = ""
+		resp, err = createTweet(createCtx, *createTweetRequest)
+	}
+	// The tweet we're replying to may have been deleted since, in which case start a new thread instead. Anything but
+	// Twitter turning the reply down may have posted it, so it isn't sent again as a new thread.
+	if isTwitterRejection(err) && createTweetRequest.Reply != nil {
+		logger.Warn("Twitter rejected the reply, retrying as a new thread", "in_reply_to_tweet_id", createTweetRequest.Reply.InReplyToTweetID, "error", err)
+		createTweetRequest.Reply = nil
+		resp, err = twitterAccount.twitter2Client.CreateTweet(createCtx, *createTweetRequest)
+	}
+	if err != nil {
+		return "", &postError{message: "Error creating a tweet", code: "twitter_create_tweet_error", err: &writeError{err: err}}
+	}
+	logger.Info("Tweet sent successfully")
+	if hasMedia {
+		mediaCache.clear(createCtx, twitterAccount, plannedTweet.Todo.ID, logger)
+	}
+
+	tweetID := ""
+	if resp.Tweet != nil {
+		tweetID = resp.Tweet.ID
+	}
+	if len(replyMediaIDs) > 0 && tweetID != "" {
+		tweetMediaReplies(createCtx, twitterAccount, tweetID, replyMediaIDs, cfg, logger)
+	}
+	return tweetID, nil
+}
+
+// What was uploaded for a planned tweet
+type todoMedia struct {
+	// In the order they're attached
+	MediaIDs []string `json:"media_ids"`
+	// Attachments too large to upload, which OVERSIZE_POLICY link puts in the tweet as links instead
+	OversizeLinks []string `json:"oversize_links,omitempty"`
+}
+
+// Renders and uploads everything a planned tweet attaches
+func uploadTodoMedia(ctx context.Context, plannedTweet plannedTweet, twitterAccount *twitterAccount, downloader *lib_media.Downloader, cfg Config, stats *attachmentStats, logger *slog.Logger) (todoMedia, error) {
+	todoAttributes := trace.WithAttributes(attribute.String("project.id", plannedTweet.Project.ID), attribute.String("todo.id", plannedTweet.Todo.ID))
+	// Like the tweet itself, media that has started uploading is finished even if we're asked to shut down, but not
+	// past the run's deadline
+	uploadCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		uploadCtx, cancel = context.WithDeadline(uploadCtx, deadline)
+		defer cancel()
+	}
+	media := todoMedia{MediaIDs: []string{}}
+	if plannedTweet.TextImageBody != "" {
+		textImage, err := lib_render.RenderTextCard(plannedTweet.TextImageBody, lib_render.DefaultTextCardOptions())
+		if err != nil {
+			return todoMedia{}, &postError{message: "Error rendering the todo body as an image", code: "render_text_image_error", err: err}
+		}
+		mediaID, err := uploadMedia(uploadCtx, textImage, http.DetectContentType(textImage), twitterAccount.mediaHttpClient)
+		if err != nil {
+			return todoMedia{}, &postError{message: "Error uploading the todo body image", code: "upload_attachment_error", err: err}
+		}
+		media.MediaIDs = append(media.MediaIDs, mediaID)
+	}
+
+	for _, codeBlock := range plannedTweet.CodeBlocks {
+		codeImage, err := lib_render.RenderCode(codeBlock.Code, codeBlock.Language, lib_render.DefaultCodeImageOptions())
+		if err != nil {
+			return todoMedia{}, &postError{message: "Error rendering a code block as an image", code: "render_code_image_error", err: err}
+		}
+		mediaID, err := uploadMedia(uploadCtx, codeImage, http.DetectContentType(codeImage), twitterAccount.mediaHttpClient)
+		if err != nil {
+			return todoMedia{}, &postError{message: "Error uploading a code block image", code: "upload_attachment_error", err: err}
+		}
+		// Screen readers can read the code itself
+		if err := setAltText(ctx, twitterAccount.twitter2Client.Client, mediaID, codeBlock.Code); err != nil {
+			logger.Warn("Could not set the code image's alt text", "todo_id", plannedTweet.Todo.ID, "media_id", mediaID, "error", err)
+		}
+		media.MediaIDs = append(media.MediaIDs, mediaID)
+	}
+
+	attachments, download := sortAttachments(plannedTweet.Attachments, cfg.AttachmentSort, downloader.Download, logger)
+	if cfg.CompositeAttachments && len(attachments) > 1 {
+		_, uploadSpan := tracer.Start(ctx, "upload_composite_attachment", todoAttributes)
+		upload, altText, err := uploadCompositeFromTodo(uploadCtx, attachments, plannedTweet.Todo, download, cfg, twitterAccount.mediaHttpClient, logger)
+		uploadSpan.End()
+		switch {
+		// None of the attachments were allowed, and each one has been logged already
+		case errors.Is(err, errMediaTypeNotAllowed):
+			attachments = nil
+		// Videos and other files that can't go in a grid are still worth tweeting, just as separate attachments
+		case err != nil:
+			logger.Warn("Could not composite the attachments, attaching them separately", "todo_id", plannedTweet.Todo.ID, "error", err)
+		default:
+			logger.Info("Uploaded composite attachment", "todo_id", plannedTweet.Todo.ID, "num_attachments", len(attachments), "size_bytes", upload.SizeBytes, "upload_ms", upload.UploadDuration.Milliseconds())
+			stats.NumUploaded++
+			stats.BytesUploaded += upload.SizeBytes
+			if err := setAltText(ctx, twitterAccount.twitter2Client.Client, upload.MediaID, altText); err != nil {
+				logger.Warn("Could not set the attachment's alt text", "todo_id", plannedTweet.Todo.ID, "media_id", upload.MediaID, "error", err)
+			}
+			media.MediaIDs = append(media.MediaIDs, upload.MediaID)
+			attachments = nil
+		}
+	}
+
+	watermark := ""
+	if cfg.WatermarkAttachments && len(attachments) > 0 {
+		var err error
+		watermark, err = renderWatermarkText(cfg, plannedTweet.Project, plannedTweet.Todo)
+		if err != nil {
+			logger.Warn("Could not render the watermark, uploading the attachments without it", "todo_id", plannedTweet.Todo.ID, "error", err)
+		}
+	}
+
+	// Nil turns deduplication off
+	var mediaIDsByHash map[string]string
+	if cfg.DedupAttachmentsByHash {
+		mediaIDsByHash = twitterAccount.mediaIDsByHash
+	}
+
+	usedFallbackAttachment := false
+	for _, attachment := range attachments {
+		_, uploadSpan := tracer.Start(ctx, "upload_attachment", todoAttributes)
+		upload, err := uploadAttachmentFromTodo(uploadCtx, attachment, download, watermark, mediaIDsByHash, cfg, twitterAccount.mediaHttpClient, logger)
+		// A disallowed attachment shouldn't cost us the rest of the tweet
+		if errors.Is(err, errMediaTypeNotAllowed) {
+			uploadSpan.End()
+			logger.Warn("Skipping attachment", "todo_id", plannedTweet.Todo.ID, "url", attachment.URL, "error", err)
+			continue
+		}
+		// OVERSIZE_POLICY comes before the fallback image, which is only for attachments it leaves to fail
+		if errors.Is(err, errMediaTooLarge) && cfg.OversizePolicy != OVERSIZE_POLICY_FAIL {
+			uploadSpan.End()
+			logger.Warn("Leaving off attachment that's too large", "todo_id", plannedTweet.Todo.ID, "url", attachment.URL, "policy", cfg.OversizePolicy, "error", err)
+			if cfg.OversizePolicy == OVERSIZE_POLICY_LINK {
+				media.OversizeLinks = append(media.OversizeLinks, attachment.URL)
+			}
+			continue
+		}
+		// REQUEUE_PROCESSING_ATTACHMENTS tries the whole todo again later, rather than swapping in the fallback image
+		if errors.Is(err, lib_media.ErrNotReady) && cfg.RequeueProcessingAttachments {
+			uploadSpan.End()
+			return todoMedia{}, &postError{message: "Attachment is still processing", code: "attachment_not_ready", err: err}
+		}
+		// Swap an attachment that failed to upload for the fallback image. One fallback is enough, so any others that
+		// fail after that are left off.
+		if err != nil && cfg.FallbackAttachmentURL != "" {
+			if usedFallbackAttachment {
+				uploadSpan.End()
+				logger.Warn("Skipping attachment that failed to upload, the fallback image is already attached", "todo_id", plannedTweet.Todo.ID, "url", attachment.URL, "error", err)
+				continue
+			}
+			logger.Warn("Could not upload attachment, attaching the fallback image instead", "todo_id", plannedTweet.Todo.ID, "url", attachment.URL, "error", err)
+			attachment = lib_wip.Attachment{URL: cfg.FallbackAttachmentURL}
+			upload, err = uploadAttachmentFromTodo(uploadCtx, attachment, download, "", mediaIDsByHash, cfg, twitterAccount.mediaHttpClient, logger)
+			usedFallbackAttachment = true
+		}
+		if err != nil {
+			uploadSpan.End()
+			return todoMedia{}, &postError{message: "Error uploading attachment", code: "upload_attachment_error", err: err}
+		}
+		uploadSpan.SetAttributes(attribute.String("attachment.content_type", upload.ContentType), attribute.Int("attachment.size_bytes", upload.SizeBytes), attribute.Int64("attachment.upload_ms", upload.UploadDuration.Milliseconds()), attribute.Bool("attachment.deduplicated", upload.Deduplicated))
+		uploadSpan.End()
+		if upload.Deduplicated {
+			logger.Info("Reusing the media ID of an identical attachment uploaded earlier this run", "todo_id", plannedTweet.Todo.ID, "url", attachment.URL, "media_id", upload.MediaID)
+			stats.NumDeduplicated++
+		} else {
+			logger.Info("Uploaded attachment", "todo_id", plannedTweet.Todo.ID, "content_type", upload.ContentType, "size_bytes", upload.SizeBytes, "upload_ms", upload.UploadDuration.Milliseconds())
+			stats.NumUploaded++
+			stats.BytesUploaded += upload.SizeBytes
+		}
+
+		// The attachment's own description is the best alt text, otherwise the todo at least says what the image is about.
+		// Reused media already has the alt text of the attachment it was uploaded for, which setting it again would
+		// overwrite.
+		altText := attachment.Description
+		if altText == "" {
+			altText = plannedTweet.Todo.Body
+		}
+		// Missing alt text shouldn't cost us the tweet
+		if !upload.Deduplicated {
+			if err := setAltText(ctx, twitterAccount.twitter2Client.Client, upload.MediaID, altText); err != nil {
+				logger.Warn("Could not set the attachment's alt text", "todo_id", plannedTweet.Todo.ID, "media_id", upload.MediaID, "error", err)
+			}
+		}
+		media.MediaIDs = append(media.MediaIDs, upload.MediaID)
+	}
+	return media, nil
+}
+
+// Posts the media in replies under the tweet, as many to a reply as Twitter allows, each reply following on from the
+// last so they read in order. The tweet is already out by now, so a reply that fails is logged rather than failing the
+// todo, which would only tweet it again on the next run.
+func tweetMediaReplies(ctx context.Context, twitterAccount *twitterAccount, tweetID string, mediaIDs []string, cfg Config, logger *slog.Logger) {
+	inReplyToTweetID := tweetID
+	for start := 0; start < len(mediaIDs); start += MAX_MEDIA_PER_TWEET {
+		replyMediaIDs := mediaIDs[start:min(start+MAX_MEDIA_PER_TWEET, len(mediaIDs))]
+		twitterAccount.waitForRateLimit(ctx, CREATE_TWEET_PATH, cfg.RateLimitMinRemaining, logger)
+		resp, err := twitterAccount.twitter2Client.CreateTweet(ctx, twitter2.CreateTweetRequest{
+			Media: &twitter2.CreateTweetMedia{IDs: replyMediaIDs},
+			Reply: &twitter2.CreateTweetReply{InReplyToTweetID: inReplyToTweetID},
+		})
+		if err != nil {
+			logger.Warn("Could not post the attachments in a reply", "tweet_id", tweetID, "media_ids", replyMediaIDs, "error", err)
+			return
+		}
+		if resp.Tweet == nil {
+			return
+		}
+		logger.Info("Posted attachments in a reply", "tweet_id", tweetID, "reply_tweet_id", resp.Tweet.ID, "num_attachments", len(replyMediaIDs))
+		inReplyToTweetID = resp.Tweet.ID
+	}
+}
+
+// What was uploaded for an attachment, for metrics
+type attachmentUpload struct {
+	MediaID     string
+	ContentType string
+	// The size of what was uploaded, i.e. after metadata was stripped
+	SizeBytes      int
+	UploadDuration time.Duration
+	// Set when an identical file had already been uploaded, whose media ID was used instead
+	Deduplicated bool
+}
+
+// Returns errMediaTypeNotAllowed if the attachment's type isn't allowed by the config, and errMediaTooLarge if it's too
+// big for Twitter. The type is sniffed from what was downloaded rather than taken from the Content-Type header, which
+// some hosts get wrong. Images are watermarked with the watermark text if there is one, and ones that can't be are
+// uploaded as they are. With mediaIDsByHash, a file that's byte for byte the same as one already uploaded reuses its
+// media ID, however its URL differs.
+func uploadAttachmentFromTodo(ctx context.Context, attachment lib_wip.Attachment, download downloadFunc, watermark string, mediaIDsByHash map[string]string, cfg Config, mediaHttpClient *http.Client, logger *slog.Logger) (attachmentUpload, error) {
+	respBytes, err := download(attachment.URL)
+	if err != nil {
+		return attachmentUpload{}, err
+	}
+	contentType := detectMediaType(respBytes, attachment.URL)
+	if !cfg.mediaTypes.allows(contentType) {
+		return attachmentUpload{}, fmt.Errorf("%w: %s", errMediaTypeNotAllowed, contentType)
+	}
+
+	if watermark != "" && strings.HasPrefix(contentType, "image/") {
+		watermarked, err := lib_render.Watermark(respBytes, watermark, cfg.watermarkOptions)
+		if err != nil {
+			logger.Warn("Could not watermark attachment, uploading it as it is", "url", attachment.URL, "content_type", contentType, "error", err)
+		} else {
+			respBytes = watermarked
+		}
+	}
+
+	if cfg.StripMetadata {
+		stripped, err := lib_media.StripMetadata(respBytes)
+		if err != nil {
+			logger.Warn("Could not strip the metadata from attachment, uploading it as it is", "url", attachment.URL, "error", err)
+		} else {
+			respBytes = stripped
+		}
+	}
+
+	upload := attachmentUpload{ContentType: detectMediaType(respBytes, attachment.URL), SizeBytes: len(respBytes)}
+	// Twitter would only turn it down after the whole thing was uploaded
+	if maxBytes := maxMediaBytes(mediaCategory(upload.ContentType)); upload.SizeBytes > maxBytes {
+		return attachmentUpload{}, fmt.Errorf("%w: %d bytes of %s, the limit is %d", errMediaTooLarge, upload.SizeBytes, upload.ContentType, maxBytes)
+	}
+	hash := sha256.Sum256(respBytes)
+	hashKey := hex.EncodeToString(hash[:])
+	if mediaID, ok := mediaIDsByHash[hashKey]; ok {
+		upload.MediaID = mediaID
+		upload.Deduplicated = true
+		return upload, nil
+	}
+	uploadStart := time.Now()
+	upload.MediaID, err = uploadMedia(ctx, respBytes, upload.ContentType, mediaHttpClient)
+	upload.UploadDuration = time.Since(uploadStart)
+	if err == nil && mediaIDsByHash != nil {
+		mediaIDsByHash[hashKey] = upload.MediaID
+	}
+	return upload, err
+}
+
+// Downloads the attachments and uploads them as a single grid image, along with alt text for the grid. Re-encoding the
+// images drops their metadata, so there's nothing to strip. Attachments whose type isn't allowed are left out of the grid.
+func uploadCompositeFromTodo(ctx context.Context, attachments []lib_wip.Attachment, todo lib_wip.Todo, download downloadFunc, cfg Config, mediaHttpClient *http.Client, logger *slog.Logger) (attachmentUpload, string, error) {
+	images := [][]byte{}
+	descriptions := []string{}
+	for _, attachment := range attachments {
+		respBytes, err := download(attachment.URL)
+		if err != nil {
+			return attachmentUpload{}, "", err
+		}
+		if contentType := detectMediaType(respBytes, attachment.URL); !cfg.mediaTypes.allows(contentType) {
+			logger.Warn("Skipping attachment", "todo_id", todo.ID, "url", attachment.URL, "error", fmt.Errorf("%w: %s", errMediaTypeNotAllowed, contentType))
+			continue
+		}
+		images = append(images, respBytes)
+		if attachment.Description != "" {
+			descriptions = append(descriptions, attachment.Description)
+		}
+	}
+
+	if len(images) == 0 {
+		return attachmentUpload{}, "", errMediaTypeNotAllowed
+	}
+	grid, err := lib_render.RenderGrid(images, lib_render.DefaultGridOptions())
+	if err != nil {
+		return attachmentUpload{}, "", err
+	}
+
+	altText := strings.Join(descriptions, "\n")
+	if altText == "" {
+		altText = todo.Body
+	}
+	upload := attachmentUpload{ContentType: http.DetectContentType(grid), SizeBytes: len(grid)}
+	uploadStart := time.Now()
+	upload.MediaID, err = uploadMedia(ctx, grid, upload.ContentType, mediaHttpClient)
+	upload.UploadDuration = time.Since(uploadStart)
+	return upload, altText, err
+}
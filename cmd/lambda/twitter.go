@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dghubble/oauth1"
+	twitter2 "github.com/g8rswimmer/go-twitter/v2"
+)
+
+const (
+	DEFAULT_TWITTER_USER_AGENT = "wip-to-twitter-bridge/1.0 (+https://github.com/bakatz/wip-to-twitter-bridge)"
+	MEDIA_METADATA_URL         = "https://upload.twitter.com/1.1/media/metadata/create.json"
+	MAX_ALT_TEXT_LENGTH        = 1000
+	// The lengths of the credentials X generates for apps and users
+	API_KEY_LENGTH             = 25
+	API_KEY_SECRET_LENGTH      = 50
+	ACCESS_TOKEN_SECRET_LENGTH = 45
+)
+
+type twitterCredentials struct {
+	APIKey            string `json:"api_key" yaml:"api_key"`
+	APIKeySecret      string `json:"api_key_secret" yaml:"api_key_secret"`
+	AccessToken       string `json:"access_token" yaml:"access_token"`
+	AccessTokenSecret string `json:"access_token_secret" yaml:"access_token_secret"`
+}
+
+func (c twitterCredentials) validate() error {
+	if c.APIKey == "" || c.APIKeySecret == "" || c.AccessToken == "" || c.AccessTokenSecret == "" {
+		return fmt.Errorf("api_key, api_key_secret, access_token and access_token_secret are all required")
+	}
+	return nil
+}
+
+// Access tokens start with the ID of the user they belong to
+func (c twitterCredentials) userID() string {
+	userID, _, _ := strings.Cut(c.AccessToken, "-")
+	return userID
+}
+
+func (c twitterCredentials) isEmpty() bool {
+	return c == twitterCredentials{}
+}
+
+// Pasted credentials often pick up a trailing newline or space, which only shows up later as a confusing signature error
+func (c twitterCredentials) trimmed() twitterCredentials {
+	return twitterCredentials{
+		APIKey:            strings.TrimSpace(c.APIKey),
+		APIKeySecret:      strings.TrimSpace(c.APIKeySecret),
+		AccessToken:       strings.TrimSpace(c.AccessToken),
+		AccessTokenSecret: strings.TrimSpace(c.AccessTokenSecret),
+	}
+}
+
+// Catches credentials that can't be right, like a secret pasted in place of a key, before they're used in an API call
+func (c twitterCredentials) checkFormat() error {
+	if len(c.APIKey) != API_KEY_LENGTH || !isAlphanumeric(c.APIKey) {
+		return fmt.Errorf("api_key should be %d letters and digits", API_KEY_LENGTH)
+	}
+	if len(c.APIKeySecret) != API_KEY_SECRET_LENGTH || !isAlphanumeric(c.APIKeySecret) {
+		return fmt.Errorf("api_key_secret should be %d letters and digits", API_KEY_SECRET_LENGTH)
+	}
+	userID, token, ok := strings.Cut(c.AccessToken, "-")
+	if !ok || userID == "" || strings.Trim(userID, "0123456789") != "" || !isAlphanumeric(token) {
+		return fmt.Errorf("access_token should look like <numeric user id>-<letters and digits>")
+	}
+	if len(c.AccessTokenSecret) != ACCESS_TOKEN_SECRET_LENGTH || !isAlphanumeric(c.AccessTokenSecret) {
+		return fmt.Errorf("access_token_secret should be %d letters and digits", ACCESS_TOKEN_SECRET_LENGTH)
+	}
+	return nil
+}
+
+func isAlphanumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type twitterAccount struct {
+	// Access tokens start with the ID of the user they belong to, which is handy for telling accounts apart in the logs
+	UserID string
+	// Signs requests like twitter2Client, but without a timeout since media uploads can take a while
+	mediaHttpClient *http.Client
+	twitter2Client  *twitter2.Client
+	rateLimits      *rateLimitTracker
+	// Media IDs of the attachments uploaded this run by the SHA-256 of what was uploaded, for DEDUP_ATTACHMENTS_BY_HASH.
+	// Media belongs to the account that uploaded it, so each account has its own.
+	mediaIDsByHash map[string]string
+}
+
+func newTwitterAccount(credentials twitterCredentials, userAgent string) *twitterAccount {
+	rateLimits := newRateLimitTracker()
+	mediaHttpClient, twitter2Client := setupTwitterClients(credentials.APIKey, credentials.APIKeySecret, credentials.AccessToken, credentials.AccessTokenSecret, userAgent, rateLimits)
+	return &twitterAccount{UserID: credentials.userID(), mediaHttpClient: mediaHttpClient, twitter2Client: twitter2Client, rateLimits: rateLimits, mediaIDsByHash: map[string]string{}}
+}
+
+// Checks the format of the default credentials and those of every project account
+func checkTwitterCredentialFormats(cfg Config) error {
+	if err := cfg.Twitter.checkFormat(); err != nil {
+		return fmt.Errorf("the TWITTER_* credentials look malformed: %w", err)
+	}
+	for projectID, credentials := range cfg.ProjectTwitterCredentials {
+		if err := credentials.checkFormat(); err != nil {
+			return fmt.Errorf("the TWITTER_PROJECT_ACCOUNTS credentials for project %s look malformed: %w", projectID, err)
+		}
+	}
+	return nil
+}
+
+// twitterRouter picks which account a project's todos get tweeted from
+type twitterRouter struct {
+	defaultAccount  *twitterAccount
+	projectAccounts map[string]*twitterAccount
+}
+
+func newTwitterRouter(defaultCredentials twitterCredentials, projectCredentials map[string]twitterCredentials, userAgent string) *twitterRouter {
+	router := &twitterRouter{defaultAccount: newTwitterAccount(defaultCredentials, userAgent), projectAccounts: map[string]*twitterAccount{}}
+
+	// Several projects can share an account, so only set up one set of clients per account
+	accountsByToken := map[string]*twitterAccount{defaultCredentials.AccessToken: router.defaultAccount}
+	for projectID, credentials := range projectCredentials {
+		account, ok := accountsByToken[credentials.AccessToken]
+		if !ok {
+			account = newTwitterAccount(credentials, userAgent)
+			accountsByToken[credentials.AccessToken] = account
+		}
+		router.projectAccounts[projectID] = account
+	}
+	return router
+}
+
+func (r *twitterRouter) accountFor(projectID string) *twitterAccount {
+	if account, ok := r.projectAccounts[projectID]; ok {
+		return account
+	}
+	return r.defaultAccount
+}
+
+// Sets the User-Agent header on every request so Twitter can tell the bridge's traffic apart from other clients
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't modify the request they're given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+func setupTwitterClients(twitterAPIKey string, twitterAPIKeySecret string, twitterAccessToken string, twitterAccessTokenSecret string, userAgent string, rateLimits *rateLimitTracker) (*http.Client, *twitter2.Client) {
+	baseHttpClient := &http.Client{Transport: rateLimitTransport{tracker: rateLimits, base: userAgentTransport{userAgent: userAgent, base: http.DefaultTransport}}}
+	oauth1Config := oauth1.NewConfig(twitterAPIKey, twitterAPIKeySecret)
+	oauth1Context := context.WithValue(oauth1.NoContext, oauth1.HTTPClient, baseHttpClient)
+	oauth1Token := &oauth1.Token{Token: twitterAccessToken, TokenSecret: twitterAccessTokenSecret}
+	twitterHttpClient := oauth1Config.Client(oauth1Context, oauth1Token)
+	twitterHttpClient.Timeout = CONNECTION_TIMEOUT_DURATION
+	// No timeout here: media uploads can take a while
+	mediaHttpClient := oauth1Config.Client(oauth1Context, oauth1Token)
+	twitter2Client := &twitter2.Client{
+		Authorizer: authorize{},
+		Client:     twitterHttpClient,
+		Host:       "https://api.twitter.com",
+	}
+	return mediaHttpClient, twitter2Client
+}
+
+// Sets the alt text of uploaded media. go-twitter doesn't cover the v1.1 media endpoints, so this goes through the OAuth1 HTTP client directly.
+func setAltText(ctx context.Context, twitterHttpClient *http.Client, mediaID string, altText string) error {
+	altText = truncateRunes(strings.TrimSpace(altText), MAX_ALT_TEXT_LENGTH)
+	if altText == "" {
+		return nil
+	}
+
+	requestBytes, err := json.Marshal(map[string]any{"media_id": mediaID, "alt_text": map[string]string{"text": altText}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, MEDIA_METADATA_URL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", CONTENT_TYPE_APPLICATION_JSON)
+
+	resp, err := twitterHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("twitter returned status code %d: %s", resp.StatusCode, respBytes)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	lib_wip "github.com/bakatz/wip-to-twitter-bridge/lib/wip"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	DEFAULT_WATERMARK_TEMPLATE = "{{.ProjectName}} · {{.CompletedAt}}"
+	DEFAULT_WATERMARK_FONT     = "regular"
+	WATERMARK_TIME_FORMAT      = "Jan 2, 2006 15:04"
+)
+
+// What WATERMARK_TEMPLATE can use. CompletedAt is already formatted in TIMEZONE.
+type watermarkFields struct {
+	ProjectName string
+	ProjectSlug string
+	TodoID      string
+	CompletedAt string
+}
+
+// Like the changelog URL template, tried out on made up fields so a typo fails at startup
+func parseWatermarkTemplate(text string) (*template.Template, error) {
+	watermarkTemplate, err := template.New("watermark").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := watermarkTemplate.Execute(&strings.Builder{}, watermarkFields{}); err != nil {
+		return nil, err
+	}
+	return watermarkTemplate, nil
+}
+
+// One of the bundled Go fonts by name, or the path to a TrueType or OpenType font file
+func loadWatermarkFont(name string) ([]byte, error) {
+	switch name {
+	case "regular":
+		return goregular.TTF, nil
+	case "bold":
+		return gobold.TTF, nil
+	case "mono":
+		return gomono.TTF, nil
+	}
+	return os.ReadFile(name)
+}
+
+func renderWatermarkText(cfg Config, project lib_wip.Project, todo lib_wip.Todo) (string, error) {
+	var watermarkText strings.Builder
+	fields := watermarkFields{ProjectName: project.Name, ProjectSlug: project.Slug, TodoID: todo.ID, CompletedAt: todo.CreatedAt.In(cfg.location).Format(WATERMARK_TIME_FORMAT)}
+	if err := cfg.watermarkTemplate.Execute(&watermarkText, fields); err != nil {
+		return "", fmt.Errorf("could not render the watermark: %w", err)
+	}
+	return strings.TrimSpace(watermarkText.String()), nil
+}
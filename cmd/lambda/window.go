@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// What to do with todos WIP says were completed after now, e.g. because of clock skew
+const (
+	// Leave them for the run whose window they fall in, like any other todo
+	FUTURE_COMPLETION_POLICY_WAIT = "wait"
+	// Tweet them now as if they were just completed
+	FUTURE_COMPLETION_POLICY_CLAMP = "clamp"
+	// Never tweet them
+	FUTURE_COMPLETION_POLICY_SKIP = "skip"
+)
+
+// lookbackWindow is the half-open interval [Start, End) of completion times a run is responsible for.
+// Runs are scheduled every LOOKBACK_WINDOW_MINUTES, so one run's End is the next run's Start and a todo completed exactly
+// on a boundary belongs to the later run only, rather than to both or neither.
+type lookbackWindow struct {
+	Start time.Time
+	End   time.Time
+	// Todos completed between End and FreshUntil are too fresh to tweet yet, a later run's window covers them
+	FreshUntil time.Time
+}
+
+// The grace period widens the start of the window to tolerate scheduler jitter, at the cost of possibly tweeting
+// todos completed in the last graceSeconds of the previous window twice. A minimum age moves the whole window back, so
+// todos can still be edited for a while after they're completed without any falling between two runs.
+func newLookbackWindow(now time.Time, graceSeconds int, minAgeMinutes int) lookbackWindow {
+	end := now.Add(-time.Duration(minAgeMinutes) * time.Minute)
+	return lookbackWindow{
+		Start:      end.Add(-LOOKBACK_WINDOW_MINUTES*time.Minute - time.Duration(graceSeconds)*time.Second),
+		End:        end,
+		FreshUntil: now,
+	}
+}
+
+func (w lookbackWindow) contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+func (w lookbackWindow) isTooFresh(t time.Time) bool {
+	return !t.Before(w.End) && t.Before(w.FreshUntil)
+}
+
+func (w lookbackWindow) isInFuture(t time.Time) bool {
+	return t.After(w.FreshUntil)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookbackWindowBoundaries(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		graceSeconds  int
+		minAgeMinutes int
+		completedAt   time.Time
+		wantContained bool
+	}{
+		{"exactly at the start", 0, 0, now.Add(-LOOKBACK_WINDOW_MINUTES * time.Minute), true},
+		{"just before the start", 0, 0, now.Add(-LOOKBACK_WINDOW_MINUTES*time.Minute - time.Nanosecond), false},
+		{"just before the end", 0, 0, now.Add(-time.Nanosecond), true},
+		{"exactly at the end", 0, 0, now, false},
+		{"in the grace period", 30, 0, now.Add(-LOOKBACK_WINDOW_MINUTES*time.Minute - 30*time.Second), true},
+		{"before the grace period", 30, 0, now.Add(-LOOKBACK_WINDOW_MINUTES*time.Minute - 31*time.Second), false},
+		{"exactly at the end with a minimum age", 0, 5, now.Add(-5 * time.Minute), false},
+		{"just before the end with a minimum age", 0, 5, now.Add(-5*time.Minute - time.Nanosecond), true},
+		{"exactly at the start with a minimum age", 0, 5, now.Add(-(LOOKBACK_WINDOW_MINUTES + 5) * time.Minute), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window := newLookbackWindow(now, test.graceSeconds, test.minAgeMinutes)
+			if got := window.contains(test.completedAt); got != test.wantContained {
+				t.Errorf("contains(%s) = %v, want %v for the window %s to %s", test.completedAt, got, test.wantContained, window.Start, window.End)
+			}
+		})
+	}
+}
+
+func TestLookbackWindowTooFresh(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	window := newLookbackWindow(now, 0, 5)
+	if !window.isTooFresh(now.Add(-5 * time.Minute)) {
+		t.Errorf("a todo exactly at the end should be too fresh")
+	}
+	if window.isTooFresh(now) || !window.isInFuture(now.Add(time.Nanosecond)) {
+		t.Errorf("only todos after now should be in the future")
+	}
+}
+
+// Without a grace period, back to back runs cover every moment exactly once
+func TestLookbackWindowsTile(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	previous := newLookbackWindow(now.Add(-LOOKBACK_WINDOW_MINUTES*time.Minute), 0, 5)
+	current := newLookbackWindow(now, 0, 5)
+	if !previous.End.Equal(current.Start) {
+		t.Errorf("the previous window ends at %s but this one starts at %s", previous.End, current.Start)
+	}
+}
+
+// The window is an hour of elapsed time, so it doesn't stretch or shrink when the clocks change
+func TestLookbackWindowAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	tests := []struct {
+		name          string
+		now           time.Time
+		completedAt   time.Time
+		wantContained bool
+	}{
+		// Clocks go forward from 2:00 EST to 3:00 EDT, so 3:30 EDT is an hour after 1:30 EST
+		{"spring forward, at the start", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork), time.Date(2024, 3, 10, 1, 30, 0, 0, newYork), true},
+		{"spring forward, before the start", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork), time.Date(2024, 3, 10, 1, 29, 59, 0, newYork), false},
+		{"spring forward, across the gap", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork), time.Date(2024, 3, 10, 3, 1, 0, 0, newYork), true},
+		// Clocks go back from 2:00 EDT to 1:00 EST, so 1:30 comes twice an hour apart
+		{"fall back, at the start", time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC).In(newYork), time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC).In(newYork), true},
+		{"fall back, before the start", time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC).In(newYork), time.Date(2024, 11, 3, 5, 29, 59, 0, time.UTC).In(newYork), false},
+		{"fall back, just before the end", time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC).In(newYork), time.Date(2024, 11, 3, 6, 29, 59, 0, time.UTC).In(newYork), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window := newLookbackWindow(test.now, 0, 0)
+			if got := window.contains(test.completedAt); got != test.wantContained {
+				t.Errorf("contains(%s) = %v, want %v for the window %s to %s", test.completedAt, got, test.wantContained, window.Start, window.End)
+			}
+		})
+	}
+}
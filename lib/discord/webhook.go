@@ -0,0 +1,102 @@
+package lib_discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	lib_webhook "github.com/bakatz/wip-to-twitter-bridge/lib/webhook"
+)
+
+const (
+	MAX_CONTENT_LENGTH     = 2000
+	MAX_EMBEDS             = 10
+	MAX_RATE_LIMIT_RETRIES = 3
+)
+
+type Client struct {
+	webhookURL string
+	httpClient *http.Client
+	ctx        context.Context
+	// Only set when payloads should be signed
+	signingSecret string
+}
+
+func NewClient(webhookURL string) *Client {
+	return &Client{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		ctx:        context.Background(),
+	}
+}
+
+// SetSigningSecret signs every payload with the secret, for webhook URLs that point at something that checks signatures,
+// like a relay, rather than at Discord itself
+func (c *Client) SetSigningSecret(secret string) {
+	c.signingSecret = secret
+}
+
+type Message struct {
+	Content string  `json:"content"`
+	Embeds  []Embed `json:"embeds,omitempty"`
+}
+
+type Embed struct {
+	Image *EmbedImage `json:"image,omitempty"`
+}
+
+type EmbedImage struct {
+	URL string `json:"url"`
+}
+
+type rateLimitResponse struct {
+	RetryAfter float64 `json:"retry_after"`
+	Global     bool    `json:"global"`
+}
+
+// PostMessage sends the message to the webhook, waiting and retrying when Discord rate limits us
+func (c *Client) PostMessage(message Message) error {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(c.ctx, "POST", c.webhookURL, bytes.NewReader(messageBytes))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.signingSecret != "" {
+			req.Header.Set(lib_webhook.SIGNATURE_HEADER, lib_webhook.Sign(c.signingSecret, messageBytes))
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < MAX_RATE_LIMIT_RETRIES {
+			var rateLimit rateLimitResponse
+			if err := json.Unmarshal(body, &rateLimit); err != nil {
+				return fmt.Errorf("failed to unmarshal rate limit response: %w", err)
+			}
+			time.Sleep(time.Duration(rateLimit.RetryAfter * float64(time.Second)))
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package lib_media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const INITIAL_RETRY_BACKOFF = 1 * time.Second
+
+// ErrNotReady is returned for attachments that are still being processed, which are answered with 202 Accepted until
+// they're ready to download
+var ErrNotReady = errors.New("attachment is still processing")
+
+type Downloader struct {
+	httpClient *http.Client
+	maxRetries int
+	ctx        context.Context
+	// Asked before every retry, on top of maxRetries. Nil allows them all.
+	retryAllowed func() bool
+}
+
+// NewDownloader returns a downloader that gives up on each attempt after timeout, and retries server errors and timeouts up to maxRetries times
+func NewDownloader(timeout time.Duration, maxRetries int) *Downloader {
+	return &Downloader{
+		httpClient: &http.Client{Timeout: timeout},
+		maxRetries: maxRetries,
+		ctx:        context.Background(),
+	}
+}
+
+type statusCodeError struct {
+	statusCode int
+}
+
+func (e statusCodeError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.statusCode)
+}
+
+// SetRetryAllowed makes the downloader ask retryAllowed before each retry and give up when it says no, e.g. to share a
+// retry budget with other callers
+func (d *Downloader) SetRetryAllowed(retryAllowed func() bool) {
+	d.retryAllowed = retryAllowed
+}
+
+func (d *Downloader) Download(url string) ([]byte, error) {
+	backoff := INITIAL_RETRY_BACKOFF
+	for attempt := 0; ; attempt++ {
+		body, err := d.download(url)
+		if err == nil || attempt >= d.maxRetries || !isRetryable(err) {
+			return body, err
+		}
+		if d.retryAllowed != nil && !d.retryAllowed() {
+			return body, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Downloader) download(url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(d.ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, ErrNotReady
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusCodeError{statusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, nil
+}
+
+// Only server errors and timeouts are worth retrying, anything else will fail the same way again
+func isRetryable(err error) bool {
+	var statusErr statusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,181 @@
+package lib_media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// PNG chunks that can carry metadata (EXIF, free-form text, timestamps). Everything else is needed to display the image.
+	pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+	ErrMalformedImage = errors.New("malformed image")
+
+	exifHeader = []byte("Exif\x00\x00")
+)
+
+const (
+	// The EXIF tag saying which way up the image is meant to be shown. Phones save photos as the sensor read them and
+	// rely on it to turn them upright.
+	EXIF_ORIENTATION_TAG = 0x0112
+	EXIF_TYPE_SHORT      = 3
+)
+
+// StripMetadata removes EXIF and similar metadata (GPS location, camera details, comments) from JPEG and PNG images
+// without re-encoding them. A JPEG's EXIF orientation is kept, in an EXIF segment with nothing else in it, so photos
+// still show the right way up. Any other kind of file, e.g. GIFs or videos, is returned unchanged.
+func StripMetadata(data []byte) ([]byte, error) {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	default:
+		return data, nil
+	}
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(data[:2]) // SOI
+	wroteOrientation := false
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			return nil, ErrMalformedImage
+		}
+		// Markers can be padded with any number of fill bytes
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, ErrMalformedImage
+		}
+		marker := data[pos]
+		pos++
+
+		// Markers without a payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write([]byte{0xFF, marker})
+			break
+		}
+
+		if pos+2 > len(data) {
+			return nil, ErrMalformedImage
+		}
+		segmentEnd := pos + int(binary.BigEndian.Uint16(data[pos:pos+2]))
+		if segmentEnd > len(data) {
+			return nil, ErrMalformedImage
+		}
+
+		// Once the scan starts the rest is entropy-coded image data, so copy it as is
+		if marker == 0xDA {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos:])
+			break
+		}
+
+		// APP1 (EXIF/XMP), APP13 (IPTC) and COM segments hold metadata. APP0 (JFIF), APP2 (ICC colour profile) and
+		// APP14 (Adobe colour transform) affect how the image is displayed, so those are kept.
+		isMetadata := marker == 0xE1 || marker == 0xED || marker == 0xFE || (marker >= 0xE3 && marker <= 0xEC) || marker == 0xEF
+		if !isMetadata {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos:segmentEnd])
+		} else if marker == 0xE1 && !wroteOrientation {
+			if orientation := exifOrientation(data[pos+2 : segmentEnd]); orientation > 1 {
+				out.Write(orientationOnlyAPP1(orientation))
+				wroteOrientation = true
+			}
+		}
+		pos = segmentEnd
+	}
+	return out.Bytes(), nil
+}
+
+// Reads the orientation from an APP1 segment's payload, returning 0 if it isn't EXIF or has no orientation
+func exifOrientation(payload []byte) uint16 {
+	if !bytes.HasPrefix(payload, exifHeader) {
+		return 0
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return 0
+	}
+	numEntries := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < numEntries; i++ {
+		// Each entry is a 2 byte tag, 2 byte type, 4 byte count and 4 bytes holding the value
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == EXIF_ORIENTATION_TAG && order.Uint16(tiff[entry+2:]) == EXIF_TYPE_SHORT {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// Builds an APP1 segment with EXIF that has nothing in it but the orientation
+func orientationOnlyAPP1(orientation uint16) []byte {
+	var exif bytes.Buffer
+	exif.Write(exifHeader)
+	exif.WriteString("MM\x00\x2a")
+	binary.Write(&exif, binary.BigEndian, uint32(8)) // IFD0 straight after the header
+	binary.Write(&exif, binary.BigEndian, uint16(1))
+	binary.Write(&exif, binary.BigEndian, []uint16{EXIF_ORIENTATION_TAG, EXIF_TYPE_SHORT})
+	binary.Write(&exif, binary.BigEndian, uint32(1))
+	binary.Write(&exif, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&exif, binary.BigEndian, uint32(0)) // No next IFD
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(exif.Len()+2))
+	return append(segment, exif.Bytes()...)
+}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		// Each chunk is a 4 byte length, 4 byte type, the data and a 4 byte CRC
+		if pos+8 > len(data) {
+			return nil, ErrMalformedImage
+		}
+		chunkEnd := pos + 12 + int(binary.BigEndian.Uint32(data[pos:pos+4]))
+		if chunkEnd > len(data) || chunkEnd < pos {
+			return nil, ErrMalformedImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:chunkEnd])
+		}
+		pos = chunkEnd
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,90 @@
+package lib_media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// Builds an APP1 segment with little endian EXIF holding the orientation and a made up GPS IFD pointer
+func exifAPP1(orientation uint16) []byte {
+	var exif bytes.Buffer
+	exif.Write(exifHeader)
+	exif.WriteString("II\x2a\x00")
+	binary.Write(&exif, binary.LittleEndian, uint32(8))
+	binary.Write(&exif, binary.LittleEndian, uint16(2))
+	binary.Write(&exif, binary.LittleEndian, []uint16{EXIF_ORIENTATION_TAG, EXIF_TYPE_SHORT})
+	binary.Write(&exif, binary.LittleEndian, uint32(1))
+	binary.Write(&exif, binary.LittleEndian, []uint16{orientation, 0})
+	binary.Write(&exif, binary.LittleEndian, []uint16{0x8825, 4}) // GPS IFD pointer
+	binary.Write(&exif, binary.LittleEndian, uint32(1))
+	binary.Write(&exif, binary.LittleEndian, uint32(1234))
+	binary.Write(&exif, binary.LittleEndian, uint32(0))
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(exif.Len()+2))
+	return append(segment, exif.Bytes()...)
+}
+
+func jpegWithAPP1(t *testing.T, app1 []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// Returns the payloads of the APP1 segments before the scan
+func app1Payloads(data []byte) [][]byte {
+	payloads := [][]byte{}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA; {
+		segmentEnd := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if data[pos+1] == 0xE1 {
+			payloads = append(payloads, data[pos+4:segmentEnd])
+		}
+		pos = segmentEnd
+	}
+	return payloads
+}
+
+func TestStripMetadataKeepsJPEGOrientation(t *testing.T) {
+	stripped, err := StripMetadata(jpegWithAPP1(t, exifAPP1(6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := app1Payloads(stripped)
+	if len(payloads) != 1 {
+		t.Fatalf("got %d APP1 segments, want 1", len(payloads))
+	}
+	if got := exifOrientation(payloads[0]); got != 6 {
+		t.Errorf("got orientation %d, want 6", got)
+	}
+	if !bytes.Equal(payloads[0], orientationOnlyAPP1(6)[4:]) {
+		t.Errorf("APP1 has more than the orientation in it")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG doesn't decode: %v", err)
+	}
+}
+
+func TestStripMetadataDropsUprightEXIF(t *testing.T) {
+	for _, orientation := range []uint16{0, 1} {
+		stripped, err := StripMetadata(jpegWithAPP1(t, exifAPP1(orientation)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payloads := app1Payloads(stripped); len(payloads) != 0 {
+			t.Errorf("orientation %d: got %d APP1 segments, want none", orientation, len(payloads))
+		}
+	}
+}
+
+func TestStripMetadataMalformedJPEG(t *testing.T) {
+	data := jpegWithAPP1(t, exifAPP1(6))
+	if _, err := StripMetadata(data[:20]); err == nil {
+		t.Errorf("expected an error for a truncated JPEG")
+	}
+}
@@ -0,0 +1,88 @@
+package lib_media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// JPEGOrientation returns the EXIF orientation of a JPEG, from 1 to 8, or 1 if it has none or isn't a JPEG
+func JPEGOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		// The orientation comes before the scan, and a marker without a payload there means the file isn't what it seems
+		if marker == 0xDA || marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			break
+		}
+		segmentEnd := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if segmentEnd > len(data) {
+			break
+		}
+		if marker == 0xE1 {
+			if orientation := exifOrientation(data[pos+4 : segmentEnd]); orientation >= 1 && orientation <= 8 {
+				return int(orientation)
+			}
+		}
+		pos = segmentEnd
+	}
+	return 1
+}
+
+// DecodeUpright decodes the image like image.Decode, then turns a JPEG the way its EXIF orientation says it should be
+// shown. Anything drawn on the image or encoded from it afterwards would otherwise come out sideways, since encoding
+// drops the EXIF that said which way up it goes.
+func DecodeUpright(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, format, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if format == "jpeg" {
+		img = Upright(img, JPEGOrientation(data))
+	}
+	return img, format, nil
+}
+
+// Upright applies an EXIF orientation to the image, returning it unchanged for 1 (already upright) or an unknown value
+func Upright(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// 5 to 8 turn the image a quarter, so it swaps its width and height
+	upright := image.NewRGBA(image.Rect(0, 0, width, height))
+	if orientation >= 5 {
+		upright = image.NewRGBA(image.Rect(0, 0, height, width))
+	}
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			uprightX, uprightY := x, y
+			switch orientation {
+			case 2: // Mirrored
+				uprightX = width - 1 - x
+			case 3: // Upside down
+				uprightX, uprightY = width-1-x, height-1-y
+			case 4: // Mirrored upside down
+				uprightY = height - 1 - y
+			case 5: // Mirrored and turned a quarter anticlockwise
+				uprightX, uprightY = y, x
+			case 6: // Turned a quarter anticlockwise
+				uprightX, uprightY = height-1-y, x
+			case 7: // Mirrored and turned a quarter clockwise
+				uprightX, uprightY = height-1-y, width-1-x
+			case 8: // Turned a quarter clockwise
+				uprightX, uprightY = y, width-1-x
+			}
+			copy(upright.Pix[upright.PixOffset(uprightX, uprightY):][:4], source.Pix[source.PixOffset(x, y):][:4])
+		}
+	}
+	return upright
+}
@@ -0,0 +1,109 @@
+package lib_media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// A 3x2 image whose pixels are all different, as
+//
+//	1 2 3
+//	4 5 6
+func numberedImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i + 1)
+	}
+	return img
+}
+
+func grayRows(img image.Image) [][]uint8 {
+	rows := [][]uint8{}
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		row := []uint8{}
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			row = append(row, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestUpright(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{1, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+		{2, [][]uint8{{3, 2, 1}, {6, 5, 4}}},
+		{3, [][]uint8{{6, 5, 4}, {3, 2, 1}}},
+		{4, [][]uint8{{4, 5, 6}, {1, 2, 3}}},
+		{5, [][]uint8{{1, 4}, {2, 5}, {3, 6}}},
+		{6, [][]uint8{{4, 1}, {5, 2}, {6, 3}}},
+		{7, [][]uint8{{6, 3}, {5, 2}, {4, 1}}},
+		{8, [][]uint8{{3, 6}, {2, 5}, {1, 4}}},
+		{9, [][]uint8{{1, 2, 3}, {4, 5, 6}}},
+	}
+	for _, tt := range tests {
+		got := grayRows(Upright(numberedImage(), tt.orientation))
+		if len(got) != len(tt.want) {
+			t.Errorf("orientation %d: got %v, want %v", tt.orientation, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], tt.want[i]) {
+				t.Errorf("orientation %d: got %v, want %v", tt.orientation, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDecodeUprightTurnsRotatedJPEG(t *testing.T) {
+	// Black on the left and white on the right, saved the way a phone held upright would with orientation 6
+	sideways := image.NewGray(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 16; x < 32; x++ {
+			sideways.SetGray(x, y, color.Gray{Y: 0xFF})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, sideways, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	data = append(append(append([]byte{}, data[:2]...), exifAPP1(6)...), data[2:]...)
+
+	if got := JPEGOrientation(data); got != 6 {
+		t.Fatalf("got orientation %d, want 6", got)
+	}
+	img, format, err := DecodeUpright(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("got format %s, want jpeg", format)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 32 {
+		t.Fatalf("got %v, want 16x32", img.Bounds().Size())
+	}
+	// Turned clockwise, the left of the photo is now the top
+	if top := color.GrayModel.Convert(img.At(8, 4)).(color.Gray).Y; top > 0x40 {
+		t.Errorf("top is %#x, want black", top)
+	}
+	if bottom := color.GrayModel.Convert(img.At(8, 28)).(color.Gray).Y; bottom < 0xC0 {
+		t.Errorf("bottom is %#x, want white", bottom)
+	}
+}
+
+func TestJPEGOrientationWithoutEXIF(t *testing.T) {
+	if got := JPEGOrientation(jpegWithAPP1(t, nil)); got != 1 {
+		t.Errorf("got orientation %d, want 1", got)
+	}
+	if got := JPEGOrientation([]byte("\x89PNG\r\n\x1a\n")); got != 1 {
+		t.Errorf("got orientation %d for a PNG, want 1", got)
+	}
+}
@@ -0,0 +1,103 @@
+package lib_render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type CodeImageOptions struct {
+	Padding  int
+	FontSize float64
+	// A chroma style name, like monokai or github
+	Style    string
+	TabWidth int
+	// Lines longer than this many characters are cut off, so one long line can't make the image unreadably wide
+	MaxColumns int
+}
+
+func DefaultCodeImageOptions() CodeImageOptions {
+	return CodeImageOptions{
+		Padding:    48,
+		FontSize:   28,
+		Style:      "monokai",
+		TabWidth:   4,
+		MaxColumns: 100,
+	}
+}
+
+// RenderCode draws the code syntax highlighted in a monospace font and returns it as a PNG. The language is a name or
+// alias like "go" or "js". When it's empty or unknown, the language is guessed from the code.
+func RenderCode(code string, language string, opts CodeImageOptions) ([]byte, error) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	style := styles.Get(opts.Style)
+
+	code = strings.ReplaceAll(strings.Trim(code, "\n"), "\t", strings.Repeat(" ", opts.TabWidth))
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to tokenise code: %w", err)
+	}
+	lines := chroma.SplitTokensIntoLines(iterator.Tokens())
+
+	parsedFont, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: opts.FontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	defer face.Close()
+
+	// Every character is the same width in a monospace font
+	columnWidth := font.MeasureString(face, "M").Ceil()
+	columns := 1
+	for _, line := range strings.Split(code, "\n") {
+		columns = max(columns, min(len([]rune(line)), opts.MaxColumns))
+	}
+	lineHeight := face.Metrics().Height.Ceil()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2*opts.Padding+columns*columnWidth, 2*opts.Padding+len(lines)*lineHeight))
+	background := style.Get(chroma.Background).Background
+	draw.Draw(img, img.Bounds(), image.NewUniform(toColor(background, color.Black)), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{Dst: img, Face: face}
+	for i, line := range lines {
+		drawer.Dot = fixed.P(opts.Padding, opts.Padding+i*lineHeight+face.Metrics().Ascent.Ceil())
+		column := 0
+		for _, token := range line {
+			text := strings.TrimRight(token.Value, "\n")
+			if column+len([]rune(text)) > opts.MaxColumns {
+				text = string([]rune(text)[:max(opts.MaxColumns-column, 0)])
+			}
+			drawer.Src = image.NewUniform(toColor(style.Get(token.Type).Colour, color.White))
+			drawer.DrawString(text)
+			column += len([]rune(text))
+		}
+	}
+
+	return EncodePNG(img)
+}
+
+func toColor(colour chroma.Colour, fallback color.Color) color.Color {
+	if !colour.IsSet() {
+		return fallback
+	}
+	return color.RGBA{R: colour.Red(), G: colour.Green(), B: colour.Blue(), A: 0xff}
+}
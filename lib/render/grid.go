@@ -0,0 +1,79 @@
+package lib_render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+type GridOptions struct {
+	CellWidth  int
+	CellHeight int
+	Gap        int
+	Background color.Color
+	// JPEG keeps a grid of photos a reasonable size, where PNG would be several times bigger
+	Quality int
+}
+
+func DefaultGridOptions() GridOptions {
+	return GridOptions{
+		CellWidth:  800,
+		CellHeight: 800,
+		Gap:        8,
+		Background: color.White,
+		Quality:    90,
+	}
+}
+
+// RenderGrid lays the images out in a grid, e.g. 2x2 for four of them, and returns it as a JPEG. Each image is scaled to
+// fit its cell and centered, so images with a different aspect ratio to the cell are letterboxed rather than cropped.
+// Photos are turned upright by their EXIF orientation first, which the grid wouldn't carry over.
+func RenderGrid(images [][]byte, opts GridOptions) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images to lay out")
+	}
+	decoded := make([]image.Image, 0, len(images))
+	for i, imageBytes := range images {
+		img, _, err := lib_media.DecodeUpright(imageBytes)
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %w", i+1, err)
+		}
+		decoded = append(decoded, img)
+	}
+
+	columns := int(math.Ceil(math.Sqrt(float64(len(decoded)))))
+	rows := (len(decoded) + columns - 1) / columns
+	grid := image.NewRGBA(image.Rect(0, 0, columns*opts.CellWidth+(columns-1)*opts.Gap, rows*opts.CellHeight+(rows-1)*opts.Gap))
+	draw.Draw(grid, grid.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+
+	for i, img := range decoded {
+		cellX := (i % columns) * (opts.CellWidth + opts.Gap)
+		cellY := (i / columns) * (opts.CellHeight + opts.Gap)
+		draw.CatmullRom.Scale(grid, fitRect(img.Bounds(), opts.CellWidth, opts.CellHeight).Add(image.Pt(cellX, cellY)), img, img.Bounds(), draw.Over, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, grid, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// The largest rectangle with the same aspect ratio as bounds that fits in a width x height cell, centered in it
+func fitRect(bounds image.Rectangle, width int, height int) image.Rectangle {
+	scale := math.Min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	scaledWidth := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+	scaledHeight := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+	x := (width - scaledWidth) / 2
+	y := (height - scaledHeight) / 2
+	return image.Rect(x, y, x+scaledWidth, y+scaledHeight)
+}
@@ -0,0 +1,111 @@
+package lib_render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type TextCardOptions struct {
+	Width      int
+	Padding    int
+	FontSize   float64
+	Background color.Color
+	Foreground color.Color
+}
+
+func DefaultTextCardOptions() TextCardOptions {
+	return TextCardOptions{
+		Width:      1200,
+		Padding:    64,
+		FontSize:   36,
+		Background: color.White,
+		Foreground: color.RGBA{R: 0x14, G: 0x17, B: 0x1a, A: 0xff},
+	}
+}
+
+// NewFace returns a face for the bundled Go font at the given size
+func NewFace(size float64) (font.Face, error) {
+	return NewFaceFrom(goregular.TTF, size)
+}
+
+// NewFaceFrom returns a face for the TrueType or OpenType font at the given size
+func NewFaceFrom(fontData []byte, size float64) (font.Face, error) {
+	parsedFont, err := opentype.Parse(fontData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font face: %w", err)
+	}
+	return face, nil
+}
+
+// RenderTextCard draws the text word-wrapped onto a plain card and returns it as a PNG
+func RenderTextCard(text string, opts TextCardOptions) ([]byte, error) {
+	face, err := NewFace(opts.FontSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lines := WrapText(text, face, opts.Width-2*opts.Padding)
+	lineHeight := face.Metrics().Height.Ceil()
+	height := 2*opts.Padding + len(lines)*lineHeight
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+
+	drawer := &font.Drawer{Dst: img, Src: image.NewUniform(opts.Foreground), Face: face}
+	for i, line := range lines {
+		drawer.Dot = fixed.P(opts.Padding, opts.Padding+i*lineHeight+face.Metrics().Ascent.Ceil())
+		drawer.DrawString(line)
+	}
+
+	return EncodePNG(img)
+}
+
+// WrapText splits text into lines no wider than maxWidth pixels, keeping existing line breaks
+func WrapText(text string, face font.Face, maxWidth int) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(text, "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		line := ""
+		for _, word := range words {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && font.MeasureString(face, candidate).Ceil() > maxWidth {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+package lib_render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	lib_media "github.com/bakatz/wip-to-twitter-bridge/lib/media"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	WATERMARK_POSITION_TOP_LEFT     = "top-left"
+	WATERMARK_POSITION_TOP_RIGHT    = "top-right"
+	WATERMARK_POSITION_BOTTOM_LEFT  = "bottom-left"
+	WATERMARK_POSITION_BOTTOM_RIGHT = "bottom-right"
+)
+
+// Returned for images that decode fine but can't be written back the way they came, like animated GIFs
+var ErrWatermarkUnsupported = errors.New("unsupported image format for watermarking")
+
+type WatermarkOptions struct {
+	Position string
+	// TrueType or OpenType font data, e.g. goregular.TTF
+	Font []byte
+	// 0 scales the text with the image, so it's about as noticeable on a phone screenshot as on a 4K one
+	FontSize   float64
+	Foreground color.Color
+	Background color.Color
+	// JPEGs are encoded again at this quality
+	Quality int
+}
+
+func DefaultWatermarkOptions() WatermarkOptions {
+	return WatermarkOptions{
+		Position:   WATERMARK_POSITION_BOTTOM_RIGHT,
+		Font:       goregular.TTF,
+		Foreground: color.White,
+		Background: color.RGBA{A: 0x99},
+		Quality:    90,
+	}
+}
+
+// Watermark draws the text on a translucent box in a corner of the image and returns it in the format it came in.
+// Only JPEGs and PNGs are watermarked: GIFs would lose their animation and there's no WebP encoder. Re-encoding drops a
+// JPEG's EXIF, so it's turned upright first and the box ends up in the corner it's shown in.
+func Watermark(imageBytes []byte, text string, opts WatermarkOptions) ([]byte, error) {
+	img, format, err := lib_media.DecodeUpright(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, fmt.Errorf("%w: %s", ErrWatermarkUnsupported, format)
+	}
+
+	fontSize := opts.FontSize
+	if fontSize == 0 {
+		fontSize = max(14, float64(img.Bounds().Dy())/40)
+	}
+	face, err := NewFaceFrom(opts.Font, fontSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	padding := int(fontSize / 2)
+	boxWidth := font.MeasureString(face, text).Ceil() + 2*padding
+	boxHeight := face.Metrics().Height.Ceil() + padding
+	bounds := img.Bounds()
+	boxMin := image.Pt(bounds.Max.X-padding-boxWidth, bounds.Max.Y-padding-boxHeight)
+	switch opts.Position {
+	case WATERMARK_POSITION_TOP_LEFT:
+		boxMin = image.Pt(bounds.Min.X+padding, bounds.Min.Y+padding)
+	case WATERMARK_POSITION_TOP_RIGHT:
+		boxMin = image.Pt(bounds.Max.X-padding-boxWidth, bounds.Min.Y+padding)
+	case WATERMARK_POSITION_BOTTOM_LEFT:
+		boxMin = image.Pt(bounds.Min.X+padding, bounds.Max.Y-padding-boxHeight)
+	}
+	box := image.Rectangle{Min: boxMin, Max: boxMin.Add(image.Pt(boxWidth, boxHeight))}
+
+	watermarked := image.NewRGBA(bounds)
+	draw.Draw(watermarked, bounds, img, bounds.Min, draw.Src)
+	draw.Draw(watermarked, box, image.NewUniform(opts.Background), image.Point{}, draw.Over)
+	drawer := &font.Drawer{Dst: watermarked, Src: image.NewUniform(opts.Foreground), Face: face}
+	drawer.Dot = fixed.P(box.Min.X+padding, box.Min.Y+padding/2+face.Metrics().Ascent.Ceil())
+	drawer.DrawString(text)
+
+	if format == "png" {
+		return EncodePNG(watermarked)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, watermarked, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,202 @@
+package lib_state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	PARTITION_KEY          = "pk"
+	PROCESSED_KEY_PREFIX   = "processed#"
+	VALUE_KEY_PREFIX       = "value#"
+	PROCESSED_AT_ATTRIBUTE = "processed_at"
+	VALUE_ATTRIBUTE        = "value"
+	// How many times UpdateValue tries again when other runs keep changing the value under it
+	MAX_UPDATE_VALUE_ATTEMPTS = 10
+)
+
+// DynamoDBStore keeps one item per processed todo and per value in a table whose partition key is a string named "pk"
+type DynamoDBStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func NewDynamoDBStore(client *dynamodb.Client, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table}
+}
+
+func itemKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{PARTITION_KEY: &types.AttributeValueMemberS{Value: key}}
+}
+
+func (s *DynamoDBStore) getItem(ctx context.Context, key string) (map[string]types.AttributeValue, error) {
+	resp, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+	return resp.Item, nil
+}
+
+func (s *DynamoDBStore) putItem(ctx context.Context, key string, attributeName string, attributeValue string) error {
+	item := itemKey(key)
+	item[attributeName] = &types.AttributeValueMemberS{Value: attributeValue}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item}); err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) deleteItem(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(s.table), Key: itemKey(key)}); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) IsProcessed(ctx context.Context, id string) (bool, error) {
+	item, err := s.getItem(ctx, PROCESSED_KEY_PREFIX+id)
+	return item != nil, err
+}
+
+func (s *DynamoDBStore) MarkProcessed(ctx context.Context, id string) error {
+	return s.putItem(ctx, PROCESSED_KEY_PREFIX+id, PROCESSED_AT_ATTRIBUTE, time.Now().UTC().Format(time.RFC3339))
+}
+
+func (s *DynamoDBStore) UnmarkProcessed(ctx context.Context, id string) error {
+	return s.deleteItem(ctx, PROCESSED_KEY_PREFIX+id)
+}
+
+// Scans until it finds a processed item. The filter is applied after each page is read, so this can take a few pages
+// on a table with lots of values but it stops at the first match.
+func (s *DynamoDBStore) HasAnyProcessed(ctx context.Context) (bool, error) {
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		FilterExpression:          aws.String("begins_with(#pk, :prefix)"),
+		ProjectionExpression:      aws.String("#pk"),
+		ExpressionAttributeNames:  map[string]string{"#pk": PARTITION_KEY},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":prefix": &types.AttributeValueMemberS{Value: PROCESSED_KEY_PREFIX}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to scan table: %w", err)
+		}
+		if page.Count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Scans the whole table, since processed items are only keyed by their ID. It's meant for occasional reads like the
+// daily stats rather than anything done per todo.
+func (s *DynamoDBStore) ProcessedSince(ctx context.Context, since time.Time) ([]string, error) {
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+		// processed_at is RFC3339 in UTC, which sorts the same as a string as it does as a time
+		FilterExpression:         aws.String("begins_with(#pk, :prefix) AND #processed_at >= :since"),
+		ProjectionExpression:     aws.String("#pk"),
+		ExpressionAttributeNames: map[string]string{"#pk": PARTITION_KEY, "#processed_at": PROCESSED_AT_ATTRIBUTE},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: PROCESSED_KEY_PREFIX},
+			":since":  &types.AttributeValueMemberS{Value: since.UTC().Format(time.RFC3339)},
+		},
+	})
+	ids := []string{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		for _, item := range page.Items {
+			if key, ok := item[PARTITION_KEY].(*types.AttributeValueMemberS); ok {
+				ids = append(ids, strings.TrimPrefix(key.Value, PROCESSED_KEY_PREFIX))
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *DynamoDBStore) GetValue(ctx context.Context, key string) (string, error) {
+	item, err := s.getItem(ctx, VALUE_KEY_PREFIX+key)
+	if err != nil || item == nil {
+		return "", err
+	}
+	value, ok := item[VALUE_ATTRIBUTE].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", fmt.Errorf("item %s has no string value", key)
+	}
+	return value.Value, nil
+}
+
+func (s *DynamoDBStore) PutValue(ctx context.Context, key string, value string) error {
+	return s.putItem(ctx, VALUE_KEY_PREFIX+key, VALUE_ATTRIBUTE, value)
+}
+
+func (s *DynamoDBStore) DeleteValue(ctx context.Context, key string) error {
+	return s.deleteItem(ctx, VALUE_KEY_PREFIX+key)
+}
+
+// Reads the value and writes the update with a condition that the value is still what was read, starting over if another
+// run changed it in the meantime
+func (s *DynamoDBStore) UpdateValue(ctx context.Context, key string, update func(value string) (string, error)) error {
+	for attempt := 1; ; attempt++ {
+		value, err := s.GetValue(ctx, key)
+		if err != nil {
+			return err
+		}
+		newValue, err := update(value)
+		if err != nil || newValue == value {
+			return err
+		}
+
+		// A value that was never stored may also be stored as an empty string
+		condition := "#value = :value"
+		conditionNames := map[string]string{"#value": VALUE_ATTRIBUTE}
+		if value == "" {
+			condition = "attribute_not_exists(#pk) OR #value = :value"
+			conditionNames["#pk"] = PARTITION_KEY
+		}
+		conditionValues := map[string]types.AttributeValue{":value": &types.AttributeValueMemberS{Value: value}}
+		if newValue == "" {
+			_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:                 aws.String(s.table),
+				Key:                       itemKey(VALUE_KEY_PREFIX + key),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  conditionNames,
+				ExpressionAttributeValues: conditionValues,
+			})
+		} else {
+			item := itemKey(VALUE_KEY_PREFIX + key)
+			item[VALUE_ATTRIBUTE] = &types.AttributeValueMemberS{Value: newValue}
+			_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+				TableName:                 aws.String(s.table),
+				Item:                      item,
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  conditionNames,
+				ExpressionAttributeValues: conditionValues,
+			})
+		}
+		var conditionFailed *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailed) {
+			if err != nil {
+				return fmt.Errorf("failed to update item: %w", err)
+			}
+			return nil
+		}
+		if attempt == MAX_UPDATE_VALUE_ATTEMPTS {
+			return fmt.Errorf("item %s kept changing while it was being updated", key)
+		}
+	}
+}
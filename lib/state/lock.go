@@ -0,0 +1,70 @@
+package lib_state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	LOCK_KEY_PREFIX = "lock#"
+	OWNER_ATTRIBUTE = "owner"
+	// In epoch seconds, so it can double as the table's TTL attribute and clean up locks left behind by crashed runs
+	EXPIRES_AT_ATTRIBUTE = "expires_at"
+)
+
+// Locker is implemented by stores that can stop two runs from working at the same time
+type Locker interface {
+	// AcquireLock returns false if someone else holds an unexpired lock with this name
+	AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+	// ReleaseLock only releases the lock if it's still held by owner
+	ReleaseLock(ctx context.Context, name string, owner string) error
+}
+
+func (s *DynamoDBStore) AcquireLock(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	item := itemKey(LOCK_KEY_PREFIX + name)
+	item[OWNER_ATTRIBUTE] = &types.AttributeValueMemberS{Value: owner}
+	item[EXPIRES_AT_ATTRIBUTE] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ttl).Unix(), 10)}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+		// DynamoDB's TTL deletes expired items lazily, so an expired lock may still be there
+		ConditionExpression:       aws.String("attribute_not_exists(#pk) OR #expires_at < :now"),
+		ExpressionAttributeNames:  map[string]string{"#pk": PARTITION_KEY, "#expires_at": EXPIRES_AT_ATTRIBUTE},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to put lock item: %w", err)
+	}
+	return true, nil
+}
+
+func (s *DynamoDBStore) ReleaseLock(ctx context.Context, name string, owner string) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       itemKey(LOCK_KEY_PREFIX + name),
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": OWNER_ATTRIBUTE},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: owner}},
+	})
+	// The lock expired and someone else took it, so it's not ours to release anymore
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete lock item: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package lib_state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore forgets everything when the process exits, so runs only rely on the lookback window. Useful for tests
+// and for running without any persistence.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state *snapshot
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newSnapshot()}
+}
+
+func (s *MemoryStore) IsProcessed(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.state.Processed[id]
+	return ok, nil
+}
+
+func (s *MemoryStore) MarkProcessed(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Processed[id] = time.Now().UTC()
+	return nil
+}
+
+func (s *MemoryStore) UnmarkProcessed(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.state.Processed, id)
+	return nil
+}
+
+func (s *MemoryStore) HasAnyProcessed(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.state.Processed) > 0, nil
+}
+
+func (s *MemoryStore) ProcessedSince(ctx context.Context, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.processedSince(since), nil
+}
+
+func (s *MemoryStore) GetValue(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Values[key], nil
+}
+
+func (s *MemoryStore) PutValue(ctx context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Values[key] = value
+	return nil
+}
+
+func (s *MemoryStore) UpdateValue(ctx context.Context, key string, update func(value string) (string, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, err := update(s.state.Values[key])
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(s.state.Values, key)
+	} else {
+		s.state.Values[key] = value
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteValue(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.state.Values, key)
+	return nil
+}
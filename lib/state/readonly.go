@@ -0,0 +1,39 @@
+package lib_state
+
+import "context"
+
+// ReadOnlyStore reads from the store it wraps but silently drops every write, so a run can see what has been processed
+// without changing it
+type ReadOnlyStore struct {
+	Store
+}
+
+func NewReadOnlyStore(store Store) *ReadOnlyStore {
+	return &ReadOnlyStore{Store: store}
+}
+
+func (s *ReadOnlyStore) MarkProcessed(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *ReadOnlyStore) UnmarkProcessed(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *ReadOnlyStore) PutValue(ctx context.Context, key string, value string) error {
+	return nil
+}
+
+func (s *ReadOnlyStore) DeleteValue(ctx context.Context, key string) error {
+	return nil
+}
+
+// Still calls update, so whatever it works out along the way is the same as on a normal run
+func (s *ReadOnlyStore) UpdateValue(ctx context.Context, key string, update func(value string) (string, error)) error {
+	value, err := s.Store.GetValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, err = update(value)
+	return err
+}
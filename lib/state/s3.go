@@ -0,0 +1,187 @@
+package lib_state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Store keeps the whole state as a single JSON object. It's read once on first use and written back after every change,
+// so runs sharing it mustn't overlap. Run locks need the DynamoDB store for that reason.
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	key    string
+	// Processed records older than this are dropped whenever the state is saved, so the object doesn't grow forever
+	retention time.Duration
+
+	mu    sync.Mutex
+	state *snapshot
+}
+
+func NewS3Store(client *s3.Client, bucket string, key string) *S3Store {
+	return &S3Store{client: client, bucket: bucket, key: key}
+}
+
+// SetRetention sets how long processed records are kept. They're kept forever by default, which is fine for small
+// deployments but means every write uploads the whole history again.
+func (s *S3Store) SetRetention(retention time.Duration) {
+	s.retention = retention
+}
+
+func (s *S3Store) load(ctx context.Context) error {
+	if s.state != nil {
+		return nil
+	}
+
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		s.state = newSnapshot()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get state object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	stateBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read state object: %w", err)
+	}
+	state := newSnapshot()
+	if err := json.Unmarshal(stateBytes, state); err != nil {
+		return fmt.Errorf("failed to unmarshal state object: %w", err)
+	}
+	s.state = state
+	return nil
+}
+
+func (s *S3Store) save(ctx context.Context) error {
+	if s.retention > 0 {
+		s.state.pruneProcessed(time.Now().Add(-s.retention))
+	}
+	stateBytes, err := json.Marshal(s.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state object: %w", err)
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(stateBytes),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put state object: %w", err)
+	}
+	return nil
+}
+
+// Loads the state if needed and applies the change, saving the state back to S3 if anything changed
+func (s *S3Store) update(ctx context.Context, change func(state *snapshot) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	if !change(s.state) {
+		return nil
+	}
+	return s.save(ctx)
+}
+
+func (s *S3Store) IsProcessed(ctx context.Context, id string) (bool, error) {
+	processed := false
+	err := s.update(ctx, func(state *snapshot) bool {
+		_, processed = state.Processed[id]
+		return false
+	})
+	return processed, err
+}
+
+func (s *S3Store) MarkProcessed(ctx context.Context, id string) error {
+	return s.update(ctx, func(state *snapshot) bool {
+		state.Processed[id] = time.Now().UTC()
+		return true
+	})
+}
+
+func (s *S3Store) UnmarkProcessed(ctx context.Context, id string) error {
+	return s.update(ctx, func(state *snapshot) bool {
+		_, ok := state.Processed[id]
+		delete(state.Processed, id)
+		return ok
+	})
+}
+
+func (s *S3Store) HasAnyProcessed(ctx context.Context) (bool, error) {
+	hasAny := false
+	err := s.update(ctx, func(state *snapshot) bool {
+		hasAny = len(state.Processed) > 0
+		return false
+	})
+	return hasAny, err
+}
+
+func (s *S3Store) ProcessedSince(ctx context.Context, since time.Time) ([]string, error) {
+	var ids []string
+	err := s.update(ctx, func(state *snapshot) bool {
+		ids = state.processedSince(since)
+		return false
+	})
+	return ids, err
+}
+
+func (s *S3Store) GetValue(ctx context.Context, key string) (string, error) {
+	value := ""
+	err := s.update(ctx, func(state *snapshot) bool {
+		value = state.Values[key]
+		return false
+	})
+	return value, err
+}
+
+func (s *S3Store) PutValue(ctx context.Context, key string, value string) error {
+	return s.update(ctx, func(state *snapshot) bool {
+		state.Values[key] = value
+		return true
+	})
+}
+
+func (s *S3Store) UpdateValue(ctx context.Context, key string, update func(value string) (string, error)) error {
+	var updateErr error
+	err := s.update(ctx, func(state *snapshot) bool {
+		value := ""
+		value, updateErr = update(state.Values[key])
+		if updateErr != nil || value == state.Values[key] {
+			return false
+		}
+		if value == "" {
+			delete(state.Values, key)
+		} else {
+			state.Values[key] = value
+		}
+		return true
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+func (s *S3Store) DeleteValue(ctx context.Context, key string) error {
+	return s.update(ctx, func(state *snapshot) bool {
+		_, ok := state.Values[key]
+		delete(state.Values, key)
+		return ok
+	})
+}
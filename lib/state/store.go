@@ -0,0 +1,55 @@
+package lib_state
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists what the bridge needs to remember between runs: which todos have already been processed, plus
+// small named values like thread cursors
+type Store interface {
+	IsProcessed(ctx context.Context, id string) (bool, error)
+	MarkProcessed(ctx context.Context, id string) error
+	UnmarkProcessed(ctx context.Context, id string) error
+	// HasAnyProcessed reports whether anything has ever been marked as processed
+	HasAnyProcessed(ctx context.Context) (bool, error)
+	// ProcessedSince returns the IDs of everything marked as processed at or after since
+	ProcessedSince(ctx context.Context, since time.Time) ([]string, error)
+	// GetValue returns an empty string if nothing is stored under the key
+	GetValue(ctx context.Context, key string) (string, error)
+	PutValue(ctx context.Context, key string, value string) error
+	DeleteValue(ctx context.Context, key string) error
+	// UpdateValue replaces the value under the key with what update returns for it, deleting the value if that's empty.
+	// Runs can overlap, so nothing stored in between the read and the write is overwritten. Stores shared between runs
+	// only write if the value hasn't changed since it was read and call update again if it has.
+	UpdateValue(ctx context.Context, key string, update func(value string) (string, error)) error
+}
+
+// snapshot is the whole state in one document, as kept by the memory and S3 stores
+type snapshot struct {
+	Processed map[string]time.Time `json:"processed"`
+	Values    map[string]string    `json:"values"`
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{Processed: map[string]time.Time{}, Values: map[string]string{}}
+}
+
+func (s *snapshot) processedSince(since time.Time) []string {
+	ids := []string{}
+	for id, processedAt := range s.Processed {
+		if !processedAt.Before(since) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Drops the processed records from before the cutoff
+func (s *snapshot) pruneProcessed(cutoff time.Time) {
+	for id, processedAt := range s.Processed {
+		if processedAt.Before(cutoff) {
+			delete(s.Processed, id)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package lib_text
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+const (
+	ZERO_WIDTH_JOINER  = '\u200d'
+	VARIATION_SELECTOR = '\ufe0f'
+	KEYCAP             = '\u20e3'
+)
+
+// IsEmoji reports whether a single grapheme cluster (as returned by uniseg) is an emoji, including
+// multi-codepoint ones like flags, skin tones, keycaps and ZWJ sequences such as 👩‍💻. Pictographs that show as
+// text by default, like ™ or ©, only count when followed by the emoji variation selector, and other symbols like °
+// or ^ never do.
+func IsEmoji(grapheme string) bool {
+	first, _ := utf8.DecodeRuneInString(grapheme)
+	switch {
+	case grapheme == "":
+		return false
+	case unicode.Is(unicode.Regional_Indicator, first):
+		return true
+	case strings.ContainsRune(grapheme, KEYCAP):
+		return true
+	case !isExtendedPictographic(first):
+		return false
+	}
+	return hasEmojiPresentation(first) || strings.ContainsRune(grapheme, VARIATION_SELECTOR) || strings.ContainsRune(grapheme, ZERO_WIDTH_JOINER)
+}
+
+// The standard library has no emoji properties and uniseg doesn't export its tables, so they're read off how uniseg
+// treats the rune. Only an Extended_Pictographic rune joins a following emoji into one grapheme cluster with a ZWJ.
+func isExtendedPictographic(r rune) bool {
+	return uniseg.GraphemeClusterCount(string([]rune{r, ZERO_WIDTH_JOINER, '🙂'})) == 1
+}
+
+// uniseg gives Extended_Pictographic runes a width of 2 exactly when they have the Emoji_Presentation property
+func hasEmojiPresentation(r rune) bool {
+	return uniseg.StringWidth(string(r)) == 2
+}
+
+// StripEmoji removes every emoji from the text, leaving everything else (including whitespace) untouched
+func StripEmoji(text string) string {
+	var b strings.Builder
+	graphemes := uniseg.NewGraphemes(text)
+	for graphemes.Next() {
+		if !IsEmoji(graphemes.Str()) {
+			b.WriteString(graphemes.Str())
+		}
+	}
+	return b.String()
+}
+
+// IsEmojiOnly reports whether the text has nothing in it but emoji and whitespace
+func IsEmojiOnly(text string) bool {
+	return strings.TrimSpace(StripEmoji(text)) == ""
+}
+
+// LimitEmoji keeps the first maxEmoji emoji in the text and removes the rest, along with the space before each one so
+// removing them doesn't leave gaps. Emoji are counted by grapheme cluster, so a ZWJ sequence like 👩‍💻 or a flag is
+// kept or removed whole.
+func LimitEmoji(text string, maxEmoji int) string {
+	var b strings.Builder
+	numEmoji := 0
+	pendingSpace := ""
+	graphemes := uniseg.NewGraphemes(text)
+	for graphemes.Next() {
+		grapheme := graphemes.Str()
+		switch {
+		case grapheme == " " || grapheme == "\t":
+			b.WriteString(pendingSpace)
+			pendingSpace = grapheme
+		case IsEmoji(grapheme):
+			numEmoji++
+			if numEmoji <= maxEmoji {
+				b.WriteString(pendingSpace + grapheme)
+			}
+			pendingSpace = ""
+		default:
+			b.WriteString(pendingSpace + grapheme)
+			pendingSpace = ""
+		}
+	}
+	if numEmoji <= maxEmoji {
+		return text
+	}
+	return strings.TrimRightFunc(b.String()+pendingSpace, unicode.IsSpace)
+}
@@ -0,0 +1,71 @@
+package lib_text
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		grapheme string
+		want     bool
+	}{
+		{"🚀", true},
+		{"🎉", true},
+		{"👩‍💻", true},
+		{"👍🏽", true},
+		{"🇺🇸", true},
+		{"#️⃣", true},
+		{"❤️", true},
+		{"™️", true},
+		{"™", false},
+		{"©", false},
+		{"°", false},
+		{"^", false},
+		{"`", false},
+		{"a", false},
+		{"é", false},
+		{"漢", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := IsEmoji(test.grapheme); got != test.want {
+			t.Errorf("IsEmoji(%q) = %v, want %v", test.grapheme, got, test.want)
+		}
+	}
+}
+
+func TestIsEmojiOnly(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"🎉", true},
+		{" 🎉 🚀 ", true},
+		{"🎉 done", false},
+		{"^^", false},
+		{"°", false},
+		{"", true},
+	}
+	for _, test := range tests {
+		if got := IsEmojiOnly(test.text); got != test.want {
+			t.Errorf("IsEmojiOnly(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestLimitEmoji(t *testing.T) {
+	tests := []struct {
+		text     string
+		maxEmoji int
+		want     string
+	}{
+		{"Fix `go vet` ^^ 20° ™ 🚀🚀", 1, "Fix `go vet` ^^ 20° ™ 🚀"},
+		{"Shipped 🚀 🎉 🔥", 2, "Shipped 🚀 🎉"},
+		{"Shipped 🚀 🎉", 2, "Shipped 🚀 🎉"},
+		{"👩‍💻 👩‍💻 coding", 1, "👩‍💻 coding"},
+		{"```go\nfmt.Println(1)```", 1, "```go\nfmt.Println(1)```"},
+	}
+	for _, test := range tests {
+		if got := LimitEmoji(test.text, test.maxEmoji); got != test.want {
+			t.Errorf("LimitEmoji(%q, %d) = %q, want %q", test.text, test.maxEmoji, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package lib_text
+
+import (
+	"net/url"
+	"strings"
+)
+
+// IsLink reports whether the word is a web address, with or without its scheme, e.g. https://myapp.com or www.myapp.com
+func IsLink(word string) bool {
+	if !strings.HasPrefix(word, "http://") && !strings.HasPrefix(word, "https://") {
+		if !strings.HasPrefix(word, "www.") {
+			return false
+		}
+		word = "https://" + word
+	}
+	parsedURL, err := url.Parse(word)
+	return err == nil && strings.Contains(parsedURL.Hostname(), ".")
+}
+
+// IsLinkOnly reports whether the text has at least one link and nothing else but emoji and whitespace
+func IsLinkOnly(text string) bool {
+	words := strings.Fields(StripEmoji(text))
+	for _, word := range words {
+		if !IsLink(word) {
+			return false
+		}
+	}
+	return len(words) > 0
+}
@@ -0,0 +1,38 @@
+package lib_text
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Punctuation that's left trailing off a note rather than ending a sentence: dashes of any kind, dots, ellipses,
+// commas, semicolons and colons. ? and ! are never stray, and neither are brackets or quotes, which close something.
+func isStrayPunctuation(r rune) bool {
+	return unicode.Is(unicode.Pd, r) || strings.ContainsRune(".…‥,;:", r)
+}
+
+// TrimTrailingPunctuation removes the stray punctuation a body trails off with, like "..." or " -", along with the
+// whitespace before it. A single period ending the last word is a normal full stop and is kept, and a body that's
+// nothing but punctuation is left as it is.
+func TrimTrailingPunctuation(text string) string {
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+	for {
+		withoutRun := strings.TrimRightFunc(trimmed, isStrayPunctuation)
+		run := trimmed[len(withoutRun):]
+		lastRune, _ := utf8.DecodeLastRuneInString(withoutRun)
+		endsSentence := run == "." && withoutRun != "" && !unicode.IsSpace(lastRune)
+		if run == "" || endsSentence {
+			break
+		}
+		withoutRun = strings.TrimRightFunc(withoutRun, unicode.IsSpace)
+		if withoutRun == "" {
+			break
+		}
+		trimmed = withoutRun
+	}
+	if trimmed == strings.TrimRightFunc(text, unicode.IsSpace) {
+		return text
+	}
+	return trimmed
+}
@@ -0,0 +1,41 @@
+package lib_text
+
+import "testing"
+
+func TestTrimTrailingPunctuation(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Fixed the login bug...", "Fixed the login bug"},
+		{"Fixed the login bug ...", "Fixed the login bug"},
+		{"Fixed the login bug…", "Fixed the login bug"},
+		{"Fixed the login bug -", "Fixed the login bug"},
+		{"Fixed the login bug —", "Fixed the login bug"},
+		{"Fixed the login bug - ...", "Fixed the login bug"},
+		{"Fixed the login bug,", "Fixed the login bug"},
+		{"Fixed the login bug.", "Fixed the login bug."},
+		{"Fixed the login bug .", "Fixed the login bug"},
+		{"Fixed the login bug. ...", "Fixed the login bug."},
+		{"Did it finally work?!", "Did it finally work?!"},
+		{"Did it finally work?...", "Did it finally work?"},
+		{"Shipped it!", "Shipped it!"},
+		{"Shipped it 🚀...", "Shipped it 🚀"},
+		{"Shipped it 🚀 -", "Shipped it 🚀"},
+		{"Shipped it 🎉", "Shipped it 🎉"},
+		{"Docs at https://example.com/docs/", "Docs at https://example.com/docs/"},
+		{"Docs (see https://example.com/docs)", "Docs (see https://example.com/docs)"},
+		{"Released v1.2-", "Released v1.2"},
+		{"Fixed \"the bug\"", "Fixed \"the bug\""},
+		{"Fixed the bug\n\n...\n", "Fixed the bug"},
+		{"Fixed the bug\n", "Fixed the bug\n"},
+		{"...", "..."},
+		{" - ", " - "},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := TrimTrailingPunctuation(test.text); got != test.want {
+			t.Errorf("TrimTrailingPunctuation(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
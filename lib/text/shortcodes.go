@@ -0,0 +1,22 @@
+package lib_text
+
+import (
+	"regexp"
+
+	"github.com/kyokomi/emoji/v2"
+)
+
+var (
+	shortcodePattern = regexp.MustCompile(`:[a-zA-Z0-9_+\-]+:`)
+	shortcodes       = emoji.CodeMap()
+)
+
+// ExpandShortcodes replaces shortcodes like :rocket: with the emoji they stand for. Unknown shortcodes are left as they are.
+func ExpandShortcodes(text string) string {
+	return shortcodePattern.ReplaceAllStringFunc(text, func(shortcode string) string {
+		if expanded, ok := shortcodes[shortcode]; ok {
+			return expanded
+		}
+		return shortcode
+	})
+}
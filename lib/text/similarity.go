@@ -0,0 +1,91 @@
+package lib_text
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TokenSetRatio scores how similar two texts are from 0 to 100, ignoring case, punctuation, word order and repeated
+// words. Like fuzzywuzzy's token_set_ratio, one text containing all of the other's words scores 100.
+func TokenSetRatio(a string, b string) int {
+	tokensA := tokenSet(a)
+	tokensB := tokenSet(b)
+	if len(tokensA) == 0 || len(tokensB) == 0 {
+		return 0
+	}
+
+	var common, onlyA, onlyB []string
+	for token := range tokensA {
+		if tokensB[token] {
+			common = append(common, token)
+		} else {
+			onlyA = append(onlyA, token)
+		}
+	}
+	for token := range tokensB {
+		if !tokensA[token] {
+			onlyB = append(onlyB, token)
+		}
+	}
+	sort.Strings(common)
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+
+	commonText := strings.Join(common, " ")
+	textA := strings.TrimSpace(commonText + " " + strings.Join(onlyA, " "))
+	textB := strings.TrimSpace(commonText + " " + strings.Join(onlyB, " "))
+	best := ratio(textA, textB)
+	if commonText != "" {
+		best = max(best, ratio(commonText, textA), ratio(commonText, textB))
+	}
+	return best
+}
+
+// Normalize reduces the text to its lowercased words, leaving out punctuation, emoji, hashtags, mentions and links, so
+// texts that only differ in those come out the same
+func Normalize(text string) string {
+	words := []string{}
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, "#") || strings.HasPrefix(word, "@") || IsLink(word) {
+			continue
+		}
+		words = append(words, strings.FieldsFunc(strings.ToLower(word), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})...)
+	}
+	return strings.Join(words, " ")
+}
+
+func tokenSet(text string) map[string]bool {
+	tokens := map[string]bool{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		tokens[token] = true
+	}
+	return tokens
+}
+
+// Scores two strings from 0 to 100 by their longest common subsequence, so insertions and deletions cost one and
+// substitutions two
+func ratio(a string, b string) int {
+	runesA := []rune(a)
+	runesB := []rune(b)
+	if len(runesA)+len(runesB) == 0 {
+		return 100
+	}
+	previous := make([]int, len(runesB)+1)
+	current := make([]int, len(runesB)+1)
+	for i := range runesA {
+		for j := range runesB {
+			if runesA[i] == runesB[j] {
+				current[j+1] = previous[j] + 1
+			} else {
+				current[j+1] = max(previous[j+1], current[j])
+			}
+		}
+		previous, current = current, previous
+	}
+	return 200 * previous[len(runesB)] / (len(runesA) + len(runesB))
+}
@@ -0,0 +1,27 @@
+package lib_text
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeWhitespace tidies up text for posting: Windows and old Mac line endings become \n, trailing whitespace is
+// trimmed from every line, runs of blank lines are collapsed into one and blank lines at the start and end are dropped.
+// Single line breaks and the blank line between paragraphs are left alone.
+func NormalizeWhitespace(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	lines := []string{}
+	previousBlank := true
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		blank := line == ""
+		if blank && previousBlank {
+			continue
+		}
+		lines = append(lines, line)
+		previousBlank = blank
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,76 @@
+package lib_translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client talks to a LibreTranslate compatible translation API
+type Client struct {
+	apiURL     string
+	apiKey     string
+	httpClient *http.Client
+	ctx        context.Context
+}
+
+func NewClient(apiURL string, apiKey string) *Client {
+	return &Client{
+		apiURL:     apiURL,
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		ctx:        context.Background(),
+	}
+}
+
+type translateRequest struct {
+	Q      string `json:"q"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Format string `json:"format"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+type translateResponse struct {
+	TranslatedText string `json:"translatedText"`
+}
+
+// Translate detects the language of the text and translates it into the target language, e.g. "es"
+func (c *Client) Translate(text string, targetLanguage string) (string, error) {
+	requestBytes, err := json.Marshal(translateRequest{Q: text, Source: "auto", Target: targetLanguage, Format: "text", APIKey: c.apiKey})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.apiURL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, respBytes)
+	}
+
+	translation := translateResponse{}
+	if err := json.Unmarshal(respBytes, &translation); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if translation.TranslatedText == "" {
+		return "", fmt.Errorf("translation API returned an empty translation")
+	}
+	return translation.TranslatedText, nil
+}
@@ -0,0 +1,59 @@
+package lib_webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client POSTs JSON payloads to a webhook URL, e.g. one from Zapier, Make or n8n
+type Client struct {
+	webhookURL string
+	httpClient *http.Client
+	ctx        context.Context
+	// Only set when payloads should be signed
+	signingSecret string
+}
+
+func NewClient(webhookURL string) *Client {
+	return &Client{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		ctx:        context.Background(),
+	}
+}
+
+// SetSigningSecret signs every payload with the secret, in the SIGNATURE_HEADER header
+func (c *Client) SetSigningSecret(secret string) {
+	c.signingSecret = secret
+}
+
+// Post sends the payload as JSON and fails on anything but a 2xx response
+func (c *Client) Post(payload any) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.webhookURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.signingSecret != "" {
+		req.Header.Set(SIGNATURE_HEADER, Sign(c.signingSecret, payloadBytes))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
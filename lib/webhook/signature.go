@@ -0,0 +1,18 @@
+package lib_webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SIGNATURE_HEADER carries Sign's result, so the receiver can check a payload really came from the bridge
+const SIGNATURE_HEADER = "X-Signature"
+
+// Sign returns the HMAC-SHA256 of the body as "sha256=<hex>", the same format GitHub uses for its webhooks. Receivers
+// should compute it over the raw body they received and compare the two with a constant time comparison.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
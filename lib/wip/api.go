@@ -3,29 +3,74 @@ package lib_wip
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// Sends the key as the api_key query parameter, which is what WIP's personal API keys use
+	AUTH_SCHEME_QUERY = "query"
+	// Sends the key in an "Authorization: Bearer <key>" header, for OAuth access tokens
+	AUTH_SCHEME_BEARER = "bearer"
+	// Sends the key in an "Authorization: Token <key>" header
+	AUTH_SCHEME_TOKEN = "token"
+)
+
 type Client struct {
 	baseURL    string
 	apiKey     string
+	authScheme string
 	httpClient *http.Client
 	ctx        context.Context
+	cache      *responseCache
+	logger     *slog.Logger
 }
 
+// Returned when WIP rejects the credentials, which often means the key was sent with the wrong auth scheme
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Returned when WIP answers successfully but the response doesn't look like what we expect, e.g. after a change on their side
+var ErrUnexpectedSchema = errors.New("unexpected response schema")
+
 func NewClient(apiKey string) *Client {
 	return &Client{
 		baseURL:    "https://api.wip.co/v1",
 		apiKey:     apiKey,
+		authScheme: AUTH_SCHEME_QUERY,
 		httpClient: &http.Client{},
 		ctx:        context.Background(),
+		logger:     slog.New(slog.NewJSONHandler(io.Discard, nil)),
 	}
 }
 
+// SetAuthScheme controls how the key is sent: AUTH_SCHEME_QUERY (the default), AUTH_SCHEME_BEARER, AUTH_SCHEME_TOKEN, or
+// any other single word to use as a custom Authorization header scheme
+func (c *Client) SetAuthScheme(scheme string) error {
+	if err := ValidateAuthScheme(scheme); err != nil {
+		return err
+	}
+	c.authScheme = scheme
+	return nil
+}
+
+func ValidateAuthScheme(scheme string) error {
+	if scheme == "" || strings.ContainsAny(scheme, " \t\r\n") {
+		return fmt.Errorf("auth scheme must be a single word, got %q", scheme)
+	}
+	return nil
+}
+
+// SetLogger makes the client log every decoded response at debug level
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
 func (c *Client) do(req *http.Request) (*http.Response, error) {
 	return c.httpClient.Do(req.WithContext(c.ctx))
 }
@@ -42,6 +87,8 @@ type Todo struct {
 
 type Attachment struct {
 	URL string `json:"url"`
+	// Not every attachment has a description, in which case this is empty
+	Description string `json:"description"`
 }
 
 type Project struct {
@@ -110,7 +157,6 @@ func (c *Client) get(path string, limit *int, startingAfter *string) ([]byte, er
 	}
 
 	q := req.URL.Query()
-	q.Add("api_key", c.apiKey)
 
 	if limit != nil {
 		q.Add("limit", strconv.Itoa(*limit))
@@ -120,6 +166,24 @@ func (c *Client) get(path string, limit *int, startingAfter *string) ([]byte, er
 		q.Add("starting_after", *startingAfter)
 	}
 
+	// The cache key deliberately leaves out the api key so it never gets written to disk
+	cacheKey := path + "?" + q.Encode()
+	if c.cache != nil {
+		if body, ok := c.cache.get(cacheKey); ok {
+			return body, nil
+		}
+	}
+
+	switch strings.ToLower(c.authScheme) {
+	case AUTH_SCHEME_QUERY:
+		q.Add("api_key", c.apiKey)
+	case AUTH_SCHEME_BEARER:
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	case AUTH_SCHEME_TOKEN:
+		req.Header.Set("Authorization", "Token "+c.apiKey)
+	default:
+		req.Header.Set("Authorization", c.authScheme+" "+c.apiKey)
+	}
 	req.URL.RawQuery = q.Encode()
 
 	resp, err := c.do(req)
@@ -128,6 +192,9 @@ func (c *Client) get(path string, limit *int, startingAfter *string) ([]byte, er
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: status code %d with the %s auth scheme", ErrUnauthorized, resp.StatusCode, c.authScheme)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -137,6 +204,12 @@ func (c *Client) get(path string, limit *int, startingAfter *string) ([]byte, er
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	if c.cache != nil {
+		if err := c.cache.put(cacheKey, body); err != nil {
+			return nil, err
+		}
+	}
+
 	return body, nil
 }
 
@@ -150,6 +223,12 @@ func (c *Client) GetMyProjects(limit *int, startingAfter *string) (*PaginatedPro
 	if err := json.Unmarshal(respBytes, &projects); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	c.logger.Debug("Decoded WIP projects", "response", projects)
+
+	// A missing data field would otherwise look exactly like having no projects
+	if projects == nil || projects.Data == nil {
+		return nil, fmt.Errorf("%w: projects response has no data", ErrUnexpectedSchema)
+	}
 
 	return projects, nil
 }
@@ -164,6 +243,11 @@ func (c *Client) GetProjectTodos(projectID string, limit *int, startingAfter *st
 	if err := json.Unmarshal(body, &todos); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	c.logger.Debug("Decoded WIP todos", "project_id", projectID, "response", todos)
+
+	if todos == nil || todos.Data == nil {
+		return nil, fmt.Errorf("%w: todos response has no data", ErrUnexpectedSchema)
+	}
 
 	return todos, nil
 }
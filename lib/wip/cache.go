@@ -0,0 +1,61 @@
+package lib_wip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type cacheEntry struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// responseCache keeps raw API responses on disk so repeated local runs don't hit the WIP API every time
+type responseCache struct {
+	path    string
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// EnableCache makes the client serve responses from the file at path while they are younger than ttl,
+// and saves any fresh responses back to that file. Meant for local development only.
+func (c *Client) EnableCache(path string, ttl time.Duration) error {
+	cache := &responseCache{path: path, ttl: ttl, entries: map[string]cacheEntry{}}
+
+	cacheBytes, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read cache file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(cacheBytes, &cache.entries); err != nil {
+			return fmt.Errorf("failed to unmarshal cache file: %w", err)
+		}
+	}
+
+	c.cache = cache
+	return nil
+}
+
+func (rc *responseCache) get(key string) ([]byte, bool) {
+	entry, ok := rc.entries[key]
+	if !ok || time.Since(entry.FetchedAt) > rc.ttl {
+		return nil, false
+	}
+	return entry.Body, true
+}
+
+func (rc *responseCache) put(key string, body []byte) error {
+	rc.entries[key] = cacheEntry{FetchedAt: time.Now().UTC(), Body: body}
+
+	cacheBytes, err := json.Marshal(rc.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache file: %w", err)
+	}
+	if err := os.WriteFile(rc.path, cacheBytes, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}